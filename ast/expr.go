@@ -4,10 +4,12 @@ import "lox/token"
 
 type Expr interface {
 	Accept(v ExprVisitor) any
+	Span() token.Span
 }
 
 // BinaryExpr ...
 type BinaryExpr struct {
+	Node
 	Left  Expr
 	Op    token.Token
 	Right Expr
@@ -19,6 +21,7 @@ func (e *BinaryExpr) Accept(v ExprVisitor) any {
 
 // UnaryExpr ...
 type UnaryExpr struct {
+	Node
 	Op    token.Token
 	Right Expr
 }
@@ -29,6 +32,7 @@ func (e *UnaryExpr) Accept(v ExprVisitor) any {
 
 // LiteralExpr ...
 type LiteralExpr struct {
+	Node
 	Val any
 }
 
@@ -38,6 +42,7 @@ func (e *LiteralExpr) Accept(v ExprVisitor) any {
 
 // GroupingExpr ...
 type GroupingExpr struct {
+	Node
 	Expression Expr
 }
 
@@ -47,6 +52,7 @@ func (e *GroupingExpr) Accept(v ExprVisitor) any {
 
 // VariableExpr ...
 type VariableExpr struct {
+	Node
 	Name *token.Token
 }
 
@@ -56,6 +62,7 @@ func (e *VariableExpr) Accept(v ExprVisitor) any {
 
 // AssignExpr ...
 type AssignExpr struct {
+	Node
 	Name  *token.Token
 	Value Expr
 }
@@ -66,6 +73,7 @@ func (e *AssignExpr) Accept(v ExprVisitor) any {
 
 // LogicalExpr ...
 type LogicalExpr struct {
+	Node
 	Left     Expr
 	Operator *token.Token
 	Right    Expr
@@ -77,6 +85,7 @@ func (s *LogicalExpr) Accept(v ExprVisitor) any {
 
 // CallExpr ...
 type CallExpr struct {
+	Node
 	Callee    Expr
 	Paren     *token.Token
 	Arguments []Expr
@@ -88,6 +97,7 @@ func (s *CallExpr) Accept(v ExprVisitor) any {
 
 // GetExpr ...
 type GetExpr struct {
+	Node
 	Object Expr
 	Name   *token.Token
 }
@@ -98,6 +108,7 @@ func (s *GetExpr) Accept(v ExprVisitor) any {
 
 // SetExpr ...
 type SetExpr struct {
+	Node
 	Object Expr
 	Name   *token.Token
 	Value  Expr
@@ -109,6 +120,7 @@ func (s *SetExpr) Accept(v ExprVisitor) any {
 
 // ThisExpr ...
 type ThisExpr struct {
+	Node
 	Keyword *token.Token
 }
 
@@ -118,6 +130,7 @@ func (s *ThisExpr) Accept(v ExprVisitor) any {
 
 // SuperExpr
 type SuperExpr struct {
+	Node
 	Keyword *token.Token
 	Method  *token.Token
 }
@@ -125,3 +138,62 @@ type SuperExpr struct {
 func (s *SuperExpr) Accept(v ExprVisitor) any {
 	return v.VisitSuperExpr(s)
 }
+
+// FunctionExpr is an anonymous function: fun (a, b) { ... }
+type FunctionExpr struct {
+	Node
+	Keyword *token.Token
+	Params  []*token.Token
+	Body    []Stmt
+}
+
+func (e *FunctionExpr) Accept(v ExprVisitor) any {
+	return v.VisitFunctionExpr(e)
+}
+
+// ListExpr is a list literal: [a, b, c]
+type ListExpr struct {
+	Node
+	Elements []Expr
+}
+
+func (e *ListExpr) Accept(v ExprVisitor) any {
+	return v.VisitListExpr(e)
+}
+
+// IndexExpr reads an element: object[index]
+type IndexExpr struct {
+	Node
+	Object  Expr
+	Bracket *token.Token // closing bracket, for error reporting
+	Index   Expr
+}
+
+func (e *IndexExpr) Accept(v ExprVisitor) any {
+	return v.VisitIndexExpr(e)
+}
+
+// IndexSetExpr assigns an element: object[index] = value
+type IndexSetExpr struct {
+	Node
+	Object  Expr
+	Bracket *token.Token
+	Index   Expr
+	Value   Expr
+}
+
+func (e *IndexSetExpr) Accept(v ExprVisitor) any {
+	return v.VisitIndexSetExpr(e)
+}
+
+// MapExpr is a map literal: {key: value, ...}
+type MapExpr struct {
+	Node
+	Brace  *token.Token // opening brace, for error reporting
+	Keys   []Expr
+	Values []Expr
+}
+
+func (e *MapExpr) Accept(v ExprVisitor) any {
+	return v.VisitMapExpr(e)
+}
@@ -0,0 +1,17 @@
+package ast
+
+import "lox/token"
+
+// Node is embedded in every expression and statement to record the source
+// range it was parsed from.
+type Node struct {
+	Range token.Span
+}
+
+func (n *Node) Span() token.Span {
+	return n.Range
+}
+
+func (n *Node) SetSpan(span token.Span) {
+	n.Range = span
+}
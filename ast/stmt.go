@@ -5,95 +5,182 @@ import (
 )
 
 type Stmt interface {
-	Accept(v StmtVisitor)
+	Accept(v StmtVisitor) any
+	Span() token.Span
 }
 
 // PrintStmt ...
 type PrintStmt struct {
+	Node
 	Expression Expr
 }
 
-func (s *PrintStmt) Accept(v StmtVisitor) {
-	v.VisitPrintStmt(s)
+func (s *PrintStmt) Accept(v StmtVisitor) any {
+	return v.VisitPrintStmt(s)
 }
 
 // ExpressionStmt ...
 type ExpressionStmt struct {
+	Node
 	Expression Expr
 }
 
-func (s *ExpressionStmt) Accept(v StmtVisitor) {
-	v.VisitExpressionStmt(s)
+func (s *ExpressionStmt) Accept(v StmtVisitor) any {
+	return v.VisitExpressionStmt(s)
 }
 
 // VarStmt ...
 type VarStmt struct {
+	Node
 	Name        *token.Token
 	Initializer Expr
 }
 
-func (s *VarStmt) Accept(v StmtVisitor) {
-	v.VisitVarStmt(s)
+func (s *VarStmt) Accept(v StmtVisitor) any {
+	return v.VisitVarStmt(s)
 }
 
 // BlockStmt ...
 type BlockStmt struct {
+	Node
 	Statements []Stmt
 }
 
-func (s *BlockStmt) Accept(v StmtVisitor) {
-	v.VisitBlockStmt(s)
+func (s *BlockStmt) Accept(v StmtVisitor) any {
+	return v.VisitBlockStmt(s)
 }
 
 // IfStmt ...
 type IfStmt struct {
+	Node
 	Condition Expr
 	Then      Stmt
 	Else      Stmt
 }
 
-func (s *IfStmt) Accept(v StmtVisitor) {
-	v.VisitIfStmt(s)
+func (s *IfStmt) Accept(v StmtVisitor) any {
+	return v.VisitIfStmt(s)
 }
 
 // WhileStmt ...
 type WhileStmt struct {
+	Node
 	Condition Expr
 	Body      Stmt
+	Increment Expr // of a desugared for loop, run after every iteration; may be nil
 }
 
-func (s *WhileStmt) Accept(v StmtVisitor) {
-	v.VisitWhileStmt(s)
+func (s *WhileStmt) Accept(v StmtVisitor) any {
+	return v.VisitWhileStmt(s)
+}
+
+// ForInStmt iterates over a list, map, string or iterable instance:
+// for (var name in iterable) body
+type ForInStmt struct {
+	Node
+	Keyword  *token.Token
+	Name     *token.Token
+	Iterable Expr
+	Body     Stmt
+}
+
+func (s *ForInStmt) Accept(v StmtVisitor) any {
+	return v.VisitForInStmt(s)
+}
+
+// ThrowStmt
+type ThrowStmt struct {
+	Node
+	Keyword *token.Token
+	Value   Expr
+}
+
+func (s *ThrowStmt) Accept(v StmtVisitor) any {
+	return v.VisitThrowStmt(s)
+}
+
+// TryStmt is try { ... } catch (name) { ... } finally { ... }, with at
+// least one of the catch and finally clauses.
+type TryStmt struct {
+	Node
+	Keyword   *token.Token
+	Body      []Stmt
+	CatchName *token.Token // nil without a catch clause
+	Catch     []Stmt
+	Finally   []Stmt
+	// HasFinally tells an empty finally clause from a missing one.
+	HasFinally bool
+}
+
+func (s *TryStmt) Accept(v StmtVisitor) any {
+	return v.VisitTryStmt(s)
+}
+
+// ImportStmt is either import "path" as alias; or
+// from "path" import name, ...;
+type ImportStmt struct {
+	Node
+	Keyword *token.Token
+	Path    *token.Token
+	Alias   *token.Token   // nil in the from form
+	Names   []*token.Token // empty in the as form
+}
+
+func (s *ImportStmt) Accept(v StmtVisitor) any {
+	return v.VisitImportStmt(s)
+}
+
+// BreakStmt
+type BreakStmt struct {
+	Node
+	Keyword *token.Token
+}
+
+func (s *BreakStmt) Accept(v StmtVisitor) any {
+	return v.VisitBreakStmt(s)
+}
+
+// ContinueStmt
+type ContinueStmt struct {
+	Node
+	Keyword *token.Token
+}
+
+func (s *ContinueStmt) Accept(v StmtVisitor) any {
+	return v.VisitContinueStmt(s)
 }
 
 // FunctionStmt
 type FunctionStmt struct {
+	Node
 	Name   *token.Token
 	Params []*token.Token
 	Body   []Stmt
 }
 
-func (s *FunctionStmt) Accept(v StmtVisitor) {
-	v.VisitFunctionStmt(s)
+func (s *FunctionStmt) Accept(v StmtVisitor) any {
+	return v.VisitFunctionStmt(s)
 }
 
 // ReturnStmt
 type ReturnStmt struct {
+	Node
 	KeyWord *token.Token
 	Value   Expr
 }
 
-func (s *ReturnStmt) Accept(v StmtVisitor) {
-	v.VisitReturnStmt(s)
+func (s *ReturnStmt) Accept(v StmtVisitor) any {
+	return v.VisitReturnStmt(s)
 }
 
 // ClassStmt
 type ClassStmt struct {
+	Node
 	Name       *token.Token
 	SuperClass *VariableExpr
 	Methods    []*FunctionStmt
 }
 
-func (s *ClassStmt) Accept(v StmtVisitor) {
-	v.VisitClassStmt(s)
+func (s *ClassStmt) Accept(v StmtVisitor) any {
+	return v.VisitClassStmt(s)
 }
@@ -13,6 +13,11 @@ type ExprVisitor interface {
 	VisitSetExpr(*SetExpr) any
 	VisitThisExpr(*ThisExpr) any
 	VisitSuperExpr(*SuperExpr) any
+	VisitFunctionExpr(*FunctionExpr) any
+	VisitListExpr(*ListExpr) any
+	VisitMapExpr(*MapExpr) any
+	VisitIndexExpr(*IndexExpr) any
+	VisitIndexSetExpr(*IndexSetExpr) any
 }
 
 type StmtVisitor interface {
@@ -22,7 +27,13 @@ type StmtVisitor interface {
 	VisitBlockStmt(stmt *BlockStmt) any
 	VisitIfStmt(stmt *IfStmt) any
 	VisitWhileStmt(stmt *WhileStmt) any
+	VisitForInStmt(stmt *ForInStmt) any
+	VisitBreakStmt(stmt *BreakStmt) any
+	VisitContinueStmt(stmt *ContinueStmt) any
 	VisitFunctionStmt(*FunctionStmt) any
 	VisitReturnStmt(*ReturnStmt) any
+	VisitThrowStmt(*ThrowStmt) any
+	VisitTryStmt(*TryStmt) any
 	VisitClassStmt(*ClassStmt) any
+	VisitImportStmt(*ImportStmt) any
 }
@@ -0,0 +1,72 @@
+package bytecode
+
+import (
+	"lox/token"
+	"sort"
+)
+
+// Function is a compiled function, method or top-level script.
+type Function struct {
+	Name         string
+	Arity        int
+	UpvalueCount int
+	Chunk        Chunk
+}
+
+// File returns the name of the source file fn was compiled from.
+func (fn *Function) File() string {
+	for _, entry := range fn.Chunk.Spans {
+		if entry.Span.Start.File != "" {
+			return entry.Span.Start.File
+		}
+	}
+	return ""
+}
+
+// Chunk is the code of one function. Its constants are nil, booleans,
+// numbers, strings and the *Function of nested function declarations.
+type Chunk struct {
+	Code      []byte
+	Constants []any
+
+	// Spans locates the code in the source, in increasing PC order: each
+	// entry covers the instructions from its PC up to the next entry.
+	Spans []SpanEntry
+}
+
+// SpanEntry maps the instructions starting at PC to the source they were
+// compiled from.
+type SpanEntry struct {
+	PC   int
+	Span token.Span
+}
+
+// Write appends b, compiled from span, to the code.
+func (c *Chunk) Write(b byte, span token.Span) {
+	if n := len(c.Spans); n == 0 || c.Spans[n-1].Span != span {
+		c.Spans = append(c.Spans, SpanEntry{PC: len(c.Code), Span: span})
+	}
+	c.Code = append(c.Code, b)
+}
+
+// AddConstant appends v to the constant pool and returns its index.
+func (c *Chunk) AddConstant(v any) int {
+	c.Constants = append(c.Constants, v)
+	return len(c.Constants) - 1
+}
+
+// SpanAt returns the source of the instruction containing the byte at pc.
+func (c *Chunk) SpanAt(pc int) token.Span {
+	i := sort.Search(len(c.Spans), func(i int) bool {
+		return c.Spans[i].PC > pc
+	})
+	if i == 0 {
+		return token.Span{}
+	}
+	return c.Spans[i-1].Span
+}
+
+// ReadShort decodes the two-byte operand at offset.
+func (c *Chunk) ReadShort(offset int) int {
+	return int(c.Code[offset])<<8 | int(c.Code[offset+1])
+}
@@ -0,0 +1,131 @@
+package bytecode
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Disassemble writes a listing of fn and the functions it declares to w.
+// Each instruction shows its offset, source line, opcode and operands;
+// source, the text fn was compiled from, adds the source lines themselves.
+// It may be empty.
+func Disassemble(w io.Writer, fn *Function, source string) {
+	var lines []string
+	if source != "" {
+		lines = strings.Split(source, "\n")
+	}
+
+	d := &disassembler{w: w, lines: lines}
+	pending := []*Function{fn}
+	for len(pending) > 0 {
+		fn, pending = pending[0], pending[1:]
+		d.function(fn)
+		for _, c := range fn.Chunk.Constants {
+			if f, ok := c.(*Function); ok {
+				pending = append(pending, f)
+			}
+		}
+	}
+}
+
+type disassembler struct {
+	w     io.Writer
+	lines []string
+}
+
+func (d *disassembler) function(fn *Function) {
+	fmt.Fprintf(d.w, "== %s ==\n", fn.Name)
+	prevLine := 0
+	for offset := 0; offset < len(fn.Chunk.Code); {
+		line := fn.Chunk.SpanAt(offset).Start.Line
+		if line != prevLine && line > 0 && line <= len(d.lines) {
+			fmt.Fprintf(d.w, "%16s %s\n", fmt.Sprintf("%d:", line), strings.TrimSpace(d.lines[line-1]))
+		}
+
+		fmt.Fprintf(d.w, "%04d ", offset)
+		if line == prevLine {
+			fmt.Fprint(d.w, "   | ")
+		} else {
+			fmt.Fprintf(d.w, "%4d ", line)
+		}
+		prevLine = line
+		offset = d.instruction(&fn.Chunk, offset)
+	}
+	fmt.Fprintln(d.w)
+}
+
+// instruction prints the instruction at offset and returns the offset of
+// the next one.
+func (d *disassembler) instruction(c *Chunk, offset int) int {
+	op := OpCode(c.Code[offset])
+	name := op.String()
+	switch op {
+	case OpConstant, OpGetGlobal, OpDefineGlobal, OpSetGlobal, OpGetProperty, OpSetProperty, OpGetSuper, OpImport:
+		index := c.ReadShort(offset + 1)
+		fmt.Fprintf(d.w, "%-16s %4d %s\n", name, index, formatConstant(c.Constants[index]))
+		return offset + 3
+
+	case OpGetLocal, OpSetLocal, OpGetUpvalue, OpSetUpvalue, OpList, OpMap:
+		fmt.Fprintf(d.w, "%-16s %4d\n", name, c.ReadShort(offset+1))
+		return offset + 3
+
+	case OpCall:
+		fmt.Fprintf(d.w, "%-16s %4d\n", name, c.Code[offset+1])
+		return offset + 2
+
+	case OpInvoke:
+		index := c.ReadShort(offset + 1)
+		fmt.Fprintf(d.w, "%-16s %4d %s (%d args)\n", name, index, formatConstant(c.Constants[index]), c.Code[offset+3])
+		return offset + 4
+
+	case OpJump, OpJumpIfFalse, OpIterNext, OpTry:
+		jump := c.ReadShort(offset + 1)
+		fmt.Fprintf(d.w, "%-16s %4d -> %d\n", name, jump, offset+3+jump)
+		return offset + 3
+
+	case OpLoop:
+		jump := c.ReadShort(offset + 1)
+		fmt.Fprintf(d.w, "%-16s %4d -> %d\n", name, jump, offset+3-jump)
+		return offset + 3
+
+	case OpClass, OpSubclass:
+		index := c.ReadShort(offset + 1)
+		fmt.Fprintf(d.w, "%-16s %4d %s (%d methods)\n", name, index, formatConstant(c.Constants[index]), c.ReadShort(offset+3))
+		return offset + 5
+
+	case OpClosure:
+		index := c.ReadShort(offset + 1)
+		fn := c.Constants[index].(*Function)
+		fmt.Fprintf(d.w, "%-16s %4d %s\n", name, index, formatConstant(fn))
+		offset += 3
+		for n := 0; n < fn.UpvalueCount; n++ {
+			kind := "upvalue"
+			if c.Code[offset] == 1 {
+				kind = "local"
+			}
+			fmt.Fprintf(d.w, "%04d    |                     %s %d\n", offset, kind, c.ReadShort(offset+1))
+			offset += 3
+		}
+		return offset
+
+	default:
+		fmt.Fprintln(d.w, name)
+		return offset + 1
+	}
+}
+
+func formatConstant(c any) string {
+	switch c := c.(type) {
+	case nil:
+		return "nil"
+	case float64:
+		return strconv.FormatFloat(c, 'g', -1, 64)
+	case string:
+		return strconv.Quote(c)
+	case *Function:
+		return "<fn " + c.Name + ">"
+	}
+	return fmt.Sprint(c)
+}
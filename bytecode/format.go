@@ -0,0 +1,344 @@
+package bytecode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"lox/token"
+	"math"
+)
+
+// A compiled file starts with Magic followed by the format version, as a
+// two-byte big-endian number. The rest is made of unsigned varints, the
+// bytes they count, and references to the constant pool by index:
+//
+//	header    magic, version
+//	constants count, then per constant a tag and its value
+//	functions count, then per function its name, arity, upvalue count,
+//	          code and constant indexes; the first one is the script
+//	lines     per function its file name and span entries, each a PC
+//	          delta from the previous entry and the start and end
+//	          positions as line, column and offset
+//
+// Constants are shared by every function; a function constant refers to a
+// function by index.
+const (
+	Magic   = "LOXC"
+	Version = 1
+)
+
+// Tags of the values in the constant pool.
+const (
+	tagNil byte = iota
+	tagFalse
+	tagTrue
+	tagNumber // 8-byte big-endian IEEE 754 bits
+	tagString // length and UTF-8 bytes
+	tagFunction
+)
+
+// ErrFormat is wrapped by the errors reading a file that is not a valid
+// compiled script.
+var ErrFormat = errors.New("invalid compiled file")
+
+// Encode writes the compiled script fn and the functions it declares to w.
+func Encode(w io.Writer, fn *Function) error {
+	e := &encoder{index: map[*Function]int{}, pool: map[any]int{}}
+	e.addFunction(fn)
+
+	e.buf.WriteString(Magic)
+	e.buf.Write([]byte{Version >> 8, Version & 0xff})
+
+	e.uint(len(e.constants))
+	for _, c := range e.constants {
+		if err := e.constant(c); err != nil {
+			return err
+		}
+	}
+
+	e.uint(len(e.functions))
+	for _, f := range e.functions {
+		e.uint(e.constantIndex(f.Name))
+		e.uint(f.Arity)
+		e.uint(f.UpvalueCount)
+		e.bytes(f.Chunk.Code)
+		e.uint(len(f.Chunk.Constants))
+		for _, c := range f.Chunk.Constants {
+			e.uint(e.constantIndex(c))
+		}
+	}
+
+	for _, f := range e.functions {
+		e.lines(f)
+	}
+
+	_, err := w.Write(e.buf.Bytes())
+	return err
+}
+
+type encoder struct {
+	buf       bytes.Buffer
+	functions []*Function
+	index     map[*Function]int // into functions
+	constants []any
+	pool      map[any]int // index of each constant by poolKey
+}
+
+// numberKey identifies a number in the constant pool by its bits, so that
+// NaN is found too.
+type numberKey uint64
+
+func poolKey(c any) any {
+	if n, ok := c.(float64); ok {
+		return numberKey(math.Float64bits(n))
+	}
+	return c
+}
+
+// addFunction numbers fn and the functions it declares, and collects their
+// constants.
+func (e *encoder) addFunction(fn *Function) {
+	e.index[fn] = len(e.functions)
+	e.functions = append(e.functions, fn)
+	e.addConstant(fn.Name)
+	e.addConstant(fn.File())
+	for _, c := range fn.Chunk.Constants {
+		if f, ok := c.(*Function); ok {
+			if _, seen := e.index[f]; !seen {
+				e.addFunction(f)
+			}
+		}
+		e.addConstant(c)
+	}
+}
+
+func (e *encoder) addConstant(c any) {
+	if _, ok := e.pool[poolKey(c)]; !ok {
+		e.pool[poolKey(c)] = len(e.constants)
+		e.constants = append(e.constants, c)
+	}
+}
+
+func (e *encoder) constantIndex(c any) int {
+	return e.pool[poolKey(c)]
+}
+
+func (e *encoder) constant(c any) error {
+	switch c := c.(type) {
+	case nil:
+		e.buf.WriteByte(tagNil)
+	case bool:
+		if c {
+			e.buf.WriteByte(tagTrue)
+		} else {
+			e.buf.WriteByte(tagFalse)
+		}
+	case float64:
+		e.buf.WriteByte(tagNumber)
+		var bits [8]byte
+		binary.BigEndian.PutUint64(bits[:], math.Float64bits(c))
+		e.buf.Write(bits[:])
+	case string:
+		e.buf.WriteByte(tagString)
+		e.bytes([]byte(c))
+	case *Function:
+		e.buf.WriteByte(tagFunction)
+		e.uint(e.index[c])
+	default:
+		return fmt.Errorf("can't encode constant %v of type %T", c, c)
+	}
+	return nil
+}
+
+func (e *encoder) lines(f *Function) {
+	e.uint(e.constantIndex(f.File()))
+	e.uint(len(f.Chunk.Spans))
+	pc := 0
+	for _, entry := range f.Chunk.Spans {
+		e.uint(entry.PC - pc)
+		pc = entry.PC
+		for _, p := range []token.Position{entry.Span.Start, entry.Span.End} {
+			e.uint(p.Line)
+			e.uint(p.Column)
+			e.uint(p.Offset)
+		}
+	}
+}
+
+func (e *encoder) uint(n int) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutUvarint(b[:], uint64(n))])
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint(len(b))
+	e.buf.Write(b)
+}
+
+// Decode reads a compiled script written by Encode. The error wraps
+// ErrFormat when r does not hold one, or holds one in another version.
+func Decode(r io.Reader) (fn *Function, err error) {
+	d := &decoder{r: bufio.NewReader(r)}
+	defer func() {
+		if r := recover(); r != nil {
+			derr, ok := r.(decodeError)
+			if !ok {
+				panic(r)
+			}
+			fn, err = nil, derr.error
+		}
+	}()
+
+	var header [len(Magic) + 2]byte
+	d.read(header[:])
+	if string(header[:len(Magic)]) != Magic {
+		d.fail("not a compiled Lox script")
+	}
+	if v := int(header[len(Magic)])<<8 | int(header[len(Magic)+1]); v != Version {
+		d.fail(fmt.Sprintf("unsupported version %d, want %d", v, Version))
+	}
+
+	constants := make([]any, d.count())
+	functionRefs := map[int]int{} // constant index to function index
+	for n := range constants {
+		switch tag := d.byte(); tag {
+		case tagNil:
+		case tagFalse:
+			constants[n] = false
+		case tagTrue:
+			constants[n] = true
+		case tagNumber:
+			var bits [8]byte
+			d.read(bits[:])
+			constants[n] = math.Float64frombits(binary.BigEndian.Uint64(bits[:]))
+		case tagString:
+			constants[n] = string(d.bytes())
+		case tagFunction:
+			functionRefs[n] = d.uint()
+		default:
+			d.fail(fmt.Sprintf("unknown constant tag %d", tag))
+		}
+	}
+
+	functions := make([]*Function, d.count())
+	if len(functions) == 0 {
+		d.fail("no script")
+	}
+	constantRefs := make([][]int, len(functions))
+	for n := range functions {
+		f := &Function{}
+		f.Name = d.string(constants)
+		f.Arity = d.uint()
+		f.UpvalueCount = d.uint()
+		f.Chunk.Code = d.bytes()
+		refs := make([]int, d.count())
+		for i := range refs {
+			refs[i] = d.index(len(constants))
+		}
+		functions[n] = f
+		constantRefs[n] = refs
+	}
+
+	for n, f := range functions {
+		f.Chunk.Constants = make([]any, len(constantRefs[n]))
+		for i, ref := range constantRefs[n] {
+			if fnIndex, ok := functionRefs[ref]; ok {
+				if fnIndex >= len(functions) {
+					d.fail("function index out of range")
+				}
+				f.Chunk.Constants[i] = functions[fnIndex]
+			} else {
+				f.Chunk.Constants[i] = constants[ref]
+			}
+		}
+
+		name := d.string(constants)
+		f.Chunk.Spans = make([]SpanEntry, d.count())
+		pc := 0
+		for i := range f.Chunk.Spans {
+			pc += d.uint()
+			entry := SpanEntry{PC: pc}
+			for _, p := range []*token.Position{&entry.Span.Start, &entry.Span.End} {
+				p.File = name
+				p.Line = d.uint()
+				p.Column = d.uint()
+				p.Offset = d.uint()
+			}
+			f.Chunk.Spans[i] = entry
+		}
+	}
+
+	return functions[0], nil
+}
+
+type decoder struct {
+	r *bufio.Reader
+}
+
+// decodeError carries a decoding failure up to Decode.
+type decodeError struct {
+	error
+}
+
+func (d *decoder) fail(msg string) {
+	panic(decodeError{fmt.Errorf("%w: %s", ErrFormat, msg)})
+}
+
+func (d *decoder) read(b []byte) {
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		d.fail("unexpected end of file")
+	}
+}
+
+func (d *decoder) byte() byte {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		d.fail("unexpected end of file")
+	}
+	return b
+}
+
+func (d *decoder) uint() int {
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil || n > math.MaxInt32 {
+		d.fail("malformed number")
+	}
+	return int(n)
+}
+
+// count reads the length of a list, bounded so that a corrupt file can't
+// make Decode allocate much memory.
+func (d *decoder) count() int {
+	n := d.uint()
+	if n > 1<<24 {
+		d.fail("list too long")
+	}
+	return n
+}
+
+func (d *decoder) bytes() []byte {
+	b := make([]byte, d.count())
+	d.read(b)
+	return b
+}
+
+// index reads an index into a list of length n.
+func (d *decoder) index(n int) int {
+	i := d.uint()
+	if i >= n {
+		d.fail("index out of range")
+	}
+	return i
+}
+
+// string reads a reference to a string constant.
+func (d *decoder) string(constants []any) string {
+	s, ok := constants[d.index(len(constants))].(string)
+	if !ok {
+		d.fail("expected a string constant")
+	}
+	return s
+}
@@ -0,0 +1,127 @@
+// Package bytecode defines the instruction set run by the lox/vm virtual
+// machine and the compiled functions holding it.
+package bytecode
+
+import "fmt"
+
+// OpCode is the first byte of an instruction. Operands follow it: indexes
+// into the constant pool, local slots and jump offsets are two bytes, big
+// endian; argument counts are one byte.
+type OpCode byte
+
+const (
+	OpConstant     OpCode = iota // constant: push the constant
+	OpNil                        // push nil
+	OpTrue                       // push true
+	OpFalse                      // push false
+	OpPop                        // discard the top of the stack
+	OpDup                        // push a copy of the top of the stack
+	OpGetLocal                   // slot: push a local variable of the current call
+	OpSetLocal                   // slot: assign the top of the stack to a local
+	OpGetGlobal                  // name constant: push a global variable
+	OpDefineGlobal               // name constant: pop into a new global variable
+	OpSetGlobal                  // name constant: assign the top of the stack to a global
+	OpGetUpvalue                 // index: push a variable captured by the closure
+	OpSetUpvalue                 // index: assign the top of the stack to a captured variable
+	OpGetProperty                // name constant: replace an object with its property
+	OpSetProperty                // name constant: pop a value and an object, set the property, push the value
+	OpGetSuper                   // name constant: pop a class and an instance, push the bound superclass method
+	OpGetIndex                   // pop an index and a list or map, push the element
+	OpSetIndex                   // pop a value, an index and a list or map, set the element, push the value
+	OpEqual                      // pop two values, push whether they are equal
+	OpNotEqual                   // pop two values, push whether they differ
+	OpGreater                    // pop two numbers, push a > b
+	OpGreaterEqual               // pop two numbers, push a >= b
+	OpLess                       // pop two numbers, push a < b
+	OpLessEqual                  // pop two numbers, push a <= b
+	OpAdd                        // pop two numbers or strings, push their sum or concatenation
+	OpSubtract                   // pop two numbers, push a - b
+	OpMultiply                   // pop two numbers, push a * b
+	OpDivide                     // pop two numbers, push a / b
+	OpNot                        // replace a value with its logical negation
+	OpNegate                     // replace a number with its negation
+	OpPrint                      // pop a value and print it
+	OpJump                       // offset: jump forward
+	OpJumpIfFalse                // offset: jump forward when the top of the stack is falsey
+	OpLoop                       // offset: jump backward
+	OpCall                       // argument count: call the value below the arguments
+	OpInvoke                     // name constant, argument count: call a method of the value below the arguments
+	OpClosure                    // function constant, then a local flag byte and an index per upvalue: push a closure
+	OpCloseUpvalue               // move the local on top of the stack to the heap and pop it
+	OpReturn                     // return the top of the stack from the current call
+	OpClass                      // name constant, method count: pop the method closures, push a class
+	OpSubclass                   // name constant, method count: like OpClass, inheriting from the class below the methods
+	OpSuperclass                 // check that the top of the stack is a class that can be inherited from
+	OpList                       // element count: pop the elements, push a list
+	OpMap                        // entry count: pop the keys and values, push a map
+	OpIter                       // replace an iterable value with an iterator over it
+	OpIterNext                   // offset: push the next value of the iterator on top of the stack, or jump forward when it is done
+	OpThrow                      // pop a value and throw it
+	OpTry                        // offset: start a protected block whose handler is at the offset
+	OpEndTry                     // end the innermost protected block
+	OpErrorValue                 // replace a caught error with the value a catch clause binds
+	OpRethrow                    // pop a caught error and raise it again
+	OpImport                     // path constant: push the imported module
+)
+
+var opNames = [...]string{
+	OpConstant:     "CONSTANT",
+	OpNil:          "NIL",
+	OpTrue:         "TRUE",
+	OpFalse:        "FALSE",
+	OpPop:          "POP",
+	OpDup:          "DUP",
+	OpGetLocal:     "GET_LOCAL",
+	OpSetLocal:     "SET_LOCAL",
+	OpGetGlobal:    "GET_GLOBAL",
+	OpDefineGlobal: "DEFINE_GLOBAL",
+	OpSetGlobal:    "SET_GLOBAL",
+	OpGetUpvalue:   "GET_UPVALUE",
+	OpSetUpvalue:   "SET_UPVALUE",
+	OpGetProperty:  "GET_PROPERTY",
+	OpSetProperty:  "SET_PROPERTY",
+	OpGetSuper:     "GET_SUPER",
+	OpGetIndex:     "GET_INDEX",
+	OpSetIndex:     "SET_INDEX",
+	OpEqual:        "EQUAL",
+	OpNotEqual:     "NOT_EQUAL",
+	OpGreater:      "GREATER",
+	OpGreaterEqual: "GREATER_EQUAL",
+	OpLess:         "LESS",
+	OpLessEqual:    "LESS_EQUAL",
+	OpAdd:          "ADD",
+	OpSubtract:     "SUBTRACT",
+	OpMultiply:     "MULTIPLY",
+	OpDivide:       "DIVIDE",
+	OpNot:          "NOT",
+	OpNegate:       "NEGATE",
+	OpPrint:        "PRINT",
+	OpJump:         "JUMP",
+	OpJumpIfFalse:  "JUMP_IF_FALSE",
+	OpLoop:         "LOOP",
+	OpCall:         "CALL",
+	OpInvoke:       "INVOKE",
+	OpClosure:      "CLOSURE",
+	OpCloseUpvalue: "CLOSE_UPVALUE",
+	OpReturn:       "RETURN",
+	OpClass:        "CLASS",
+	OpSubclass:     "SUBCLASS",
+	OpSuperclass:   "SUPERCLASS",
+	OpList:         "LIST",
+	OpMap:          "MAP",
+	OpIter:         "ITER",
+	OpIterNext:     "ITER_NEXT",
+	OpThrow:        "THROW",
+	OpTry:          "TRY",
+	OpEndTry:       "END_TRY",
+	OpErrorValue:   "ERROR_VALUE",
+	OpRethrow:      "RETHROW",
+	OpImport:       "IMPORT",
+}
+
+func (op OpCode) String() string {
+	if int(op) < len(opNames) && opNames[op] != "" {
+		return opNames[op]
+	}
+	return fmt.Sprintf("OP_%d", byte(op))
+}
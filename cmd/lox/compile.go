@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"lox"
+	"lox/bytecode"
+	"lox/loxerr"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// compiledExt is the extension of compiled scripts.
+const compiledExt = ".loxc"
+
+// compileCommand implements "lox compile <script> [-o <file>]".
+func compileCommand(args []string) int {
+	flags := flag.NewFlagSet("compile", flag.ContinueOnError)
+	flags.Usage = usage
+	out := flags.String("o", "", "output file")
+
+	// The flag may come after the script, as in "lox compile a.lox -o a.loxc".
+	var scripts []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return exitUsage
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		scripts, args = append(scripts, args[0]), args[1:]
+	}
+	if len(scripts) != 1 {
+		usage()
+		return exitUsage
+	}
+
+	path := scripts[0]
+	if *out == "" {
+		*out = strings.TrimSuffix(path, ".lox") + compiledExt
+	}
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitNoInput
+	}
+	fn, err := lox.Compile(path, string(source))
+	if err != nil {
+		report(path, string(source), err)
+		return exitCompile
+	}
+
+	var buf bytes.Buffer
+	if err := bytecode.Encode(&buf, fn); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCompile
+	}
+	if err := os.WriteFile(*out, buf.Bytes(), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCantCreate
+	}
+	return exitOK
+}
+
+// runCompiled runs the compiled script at path on the bytecode VM.
+func runCompiled(opts lox.Options, path string, args []string) int {
+	fn, code := loadCompiled(path)
+	if fn == nil {
+		return code
+	}
+
+	opts.Args = args
+	opts.Backend = lox.Bytecode
+	vm := lox.NewVM(opts)
+	if err := vm.RunCompiled(fn); err != nil {
+		// The source is not at hand, so errors come without an excerpt.
+		report(path, "", err)
+		if _, ok := err.(*loxerr.RuntimeError); ok {
+			return exitRuntime
+		}
+		return exitCompile
+	}
+	return exitOK
+}
+
+// loadCompiled reads the compiled script at path. On failure it reports the
+// error and returns the exit code.
+func loadCompiled(path string) (*bytecode.Function, int) {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, exitNoInput
+	}
+	defer f.Close()
+
+	fn, err := bytecode.Decode(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return nil, exitCompile
+	}
+	return fn, exitOK
+}
+
+// disasmCommand implements "lox disasm <script>", listing the bytecode of
+// a script or of a compiled file.
+func disasmCommand(args []string) int {
+	if len(args) != 1 {
+		usage()
+		return exitUsage
+	}
+	path := args[0]
+
+	if filepath.Ext(path) == compiledExt {
+		fn, code := loadCompiled(path)
+		if fn == nil {
+			return code
+		}
+		bytecode.Disassemble(os.Stdout, fn, "")
+		return exitOK
+	}
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitNoInput
+	}
+	fn, err := lox.Compile(path, string(source))
+	if err != nil {
+		report(path, string(source), err)
+		return exitCompile
+	}
+	bytecode.Disassemble(os.Stdout, fn, string(source))
+	return exitOK
+}
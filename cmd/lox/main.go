@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"lox"
+	"lox/loxerr"
+	"os"
+	"path/filepath"
+)
+
+// Exit codes follow the BSD sysexits convention, as in the book.
+const (
+	exitOK         = 0
+	exitUsage      = 64
+	exitCompile    = 65
+	exitNoInput    = 66
+	exitRuntime    = 70
+	exitCantCreate = 73
+	stdinSource    = "-"
+	evalFileName   = "<eval>"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  lox                          start an interactive prompt
+  lox run <script> [args...]   run a script file
+  lox <script> [args...]       same as "lox run"
+  lox - [args...]              read the program from stdin
+  lox -e <source> [args...]    run an inline program
+  lox compile <script> [-o <file>]
+                               compile a script to bytecode, by default
+                               into the script's name with .loxc
+  lox run <file.loxc> [args...]
+                               run a compiled script on the bytecode VM
+  lox disasm <script>          print the bytecode of a script or of a
+                               compiled .loxc file
+
+Flags:
+  -backend tree|bytecode       run programs with the tree-walking
+                               interpreter (the default) or the bytecode VM
+`)
+}
+
+func main() {
+	os.Exit(cli(os.Args[1:]))
+}
+
+func cli(argv []string) int {
+	flags := flag.NewFlagSet("lox", flag.ContinueOnError)
+	flags.Usage = usage
+	eval := flags.String("e", "", "run an inline program")
+	backendName := flags.String("backend", "tree", "tree or bytecode")
+	if err := flags.Parse(argv); err != nil {
+		return exitUsage
+	}
+	backend, ok := backends[*backendName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown backend '%s'.\n", *backendName)
+		usage()
+		return exitUsage
+	}
+	opts := lox.Options{Backend: backend}
+
+	args := flags.Args()
+	if isFlagSet(flags, "e") {
+		return run(opts, evalFileName, *eval, args)
+	}
+
+	if len(args) == 0 {
+		return repl(opts, os.Stdin, os.Stdout)
+	}
+
+	switch args[0] {
+	case "run":
+		args = args[1:]
+		if len(args) == 0 {
+			usage()
+			return exitUsage
+		}
+	case "compile":
+		return compileCommand(args[1:])
+	case "disasm":
+		return disasmCommand(args[1:])
+	}
+
+	if filepath.Ext(args[0]) == compiledExt {
+		return runCompiled(opts, args[0], args[1:])
+	}
+
+	return runFile(opts, args[0], args[1:])
+}
+
+// backends maps the values of the -backend flag to the backends they
+// select.
+var backends = map[string]lox.Backend{
+	"tree":     lox.TreeWalker,
+	"bytecode": lox.Bytecode,
+}
+
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	found := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+func runFile(opts lox.Options, path string, args []string) int {
+	var (
+		content []byte
+		err     error
+	)
+	if path == stdinSource {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitNoInput
+	}
+
+	return run(opts, path, string(content), args)
+}
+
+// run runs source, named name in errors, in a VM configured by opts with the
+// script arguments args.
+func run(opts lox.Options, name string, source string, args []string) int {
+	opts.Args = args
+	vm := lox.NewVM(opts)
+	if _, err := vm.EvalSource(name, source); err != nil {
+		report(name, source, err)
+		if _, ok := err.(*loxerr.RuntimeError); ok {
+			return exitRuntime
+		}
+		return exitCompile
+	}
+
+	return exitOK
+}
+
+// report prints err to stderr. Each diagnostic that knows its position in
+// the file name, whose content is source, is followed by the offending
+// source line with the range underlined.
+func report(name string, source string, err error) {
+	errs := []error{err}
+	if list, ok := err.(loxerr.List); ok {
+		errs = list
+	}
+
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+		if spanned, ok := err.(loxerr.Spanned); ok && spanned.Span().Start.File == name {
+			if excerpt := loxerr.Excerpt(source, spanned); excerpt != "" {
+				fmt.Fprintln(os.Stderr, excerpt)
+			}
+		}
+		if rtErr, ok := err.(*loxerr.RuntimeError); ok && len(rtErr.Trace) > 0 {
+			fmt.Fprintln(os.Stderr, rtErr.Traceback())
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"lox"
+	"lox/interpreter"
+	"lox/loxerr"
+	"strings"
+)
+
+const (
+	prompt         = "> "
+	continuePrompt = "... "
+	replFileName   = "<stdin>"
+)
+
+// repl reads programs line by line from in, keeping a single VM configured
+// by opts alive so that declarations persist across inputs. A line that
+// leaves a brace, paren or string open is continued on the next line.
+func repl(opts lox.Options, in io.Reader, out io.Writer) int {
+	opts.Stdout = out
+	vm := lox.NewVM(opts)
+
+	lines := bufio.NewScanner(in)
+	var buf strings.Builder
+	fmt.Fprint(out, prompt)
+	for lines.Scan() {
+		buf.WriteString(lines.Text())
+		buf.WriteByte('\n')
+
+		source := buf.String()
+		if isIncomplete(source) {
+			fmt.Fprint(out, continuePrompt)
+			continue
+		}
+
+		buf.Reset()
+		evalLine(vm, out, source)
+		fmt.Fprint(out, prompt)
+	}
+	fmt.Fprintln(out)
+
+	return exitOK
+}
+
+// evalLine runs one complete REPL input and echoes the value of a trailing
+// bare expression. Errors are reported and swallowed so the session can go
+// on.
+func evalLine(vm *lox.VM, out io.Writer, source string) {
+	source = strings.TrimSpace(source)
+	if source == "" {
+		return
+	}
+	// Input that parses as an expression, such as `1 + 2` or `{"a": 1}`, is
+	// evaluated and echoed; anything else runs as statements.
+	val, err := vm.EvalExpression(replFileName, source)
+	if isParseError(err) {
+		val, err = vm.EvalSource(replFileName, source)
+	}
+	if _, ok := err.(*loxerr.RuntimeError); ok {
+		// The error may come from a function declared by an earlier input,
+		// so there is no source to underline.
+		report("", "", err)
+		return
+	}
+	if err != nil {
+		report(replFileName, source, err)
+		return
+	}
+
+	// Statements and expressions evaluating to nil, such as most calls,
+	// print nothing.
+	if val != nil {
+		fmt.Fprintln(out, interpreter.Stringify(val))
+	}
+}
+
+// isParseError reports whether err holds a syntax error, rather than a scan,
+// resolve or runtime error.
+func isParseError(err error) bool {
+	var list loxerr.List
+	if !errors.As(err, &list) {
+		return false
+	}
+	for _, err := range list {
+		if _, ok := err.(*loxerr.ParseError); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isIncomplete reports whether source ends inside a string literal or with
+// more '(' / '{' than matching closers.
+func isIncomplete(source string) bool {
+	depth := 0
+	inString := false
+	runes := []rune(source)
+	for idx := 0; idx < len(runes); idx++ {
+		c := runes[idx]
+		if inString {
+			if c == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '/':
+			if idx+1 < len(runes) && runes[idx+1] == '/' {
+				for idx < len(runes) && runes[idx] != '\n' {
+					idx++
+				}
+			}
+		case '(', '{', '[':
+			depth++
+		case ')', '}', ']':
+			depth--
+		}
+	}
+
+	return inString || depth > 0
+}
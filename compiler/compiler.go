@@ -0,0 +1,621 @@
+// Package compiler translates resolved syntax trees into bytecode for the
+// lox/vm virtual machine.
+//
+// Local variables live in stack slots of their function's call, numbered in
+// declaration order; variables of enclosing functions are reached through
+// upvalues, and everything else is a global looked up by name.
+package compiler
+
+import (
+	"lox/ast"
+	"lox/bytecode"
+	"lox/loxerr"
+	"lox/token"
+	"math"
+)
+
+var (
+	_ ast.ExprVisitor = (*Compiler)(nil)
+	_ ast.StmtVisitor = (*Compiler)(nil)
+)
+
+// maxOperand is the largest constant index, slot, count or jump offset an
+// instruction can encode.
+const maxOperand = math.MaxUint16
+
+// ScriptName names the function holding the top-level code of a script.
+const ScriptName = "script"
+
+// anonymousName names anonymous functions, like the interpreter does.
+const anonymousName = "anonymous"
+
+type functionKind int
+
+const (
+	kindScript functionKind = iota
+	kindFunction
+	kindMethod
+	kindInitializer
+)
+
+// Compiler compiles one program. It is not safe for concurrent use.
+type Compiler struct {
+	locals map[ast.Expr]int // resolved references to local variables
+	fn     *function        // innermost function being compiled
+	span   token.Span       // source of the instructions being emitted
+	errs   loxerr.List
+}
+
+// function is the compilation state of one function.
+type function struct {
+	enclosing *function
+	proto     *bytecode.Function
+	kind      functionKind
+	locals    []local
+	upvalues  []upvalue
+	depth     int // of the current scope; 0 is the top level of a script
+	loops     []*loop
+	tries     []try
+	constants map[any]int
+}
+
+// local is a variable in a stack slot of the current call.
+type local struct {
+	name     string // "" for the slots the compiler reserves for itself
+	depth    int
+	captured bool // by a closure, so it is closed over when it goes out of scope
+
+	// hidden locals are out of scope of the finally clause being inlined;
+	// see exitTries.
+	hidden bool
+}
+
+// upvalue is a variable of an enclosing function captured by a closure.
+type upvalue struct {
+	index   int  // slot or upvalue index in the enclosing function
+	isLocal bool // whether index is a slot of the enclosing function
+}
+
+// loop is a loop being compiled, for its break and continue statements.
+type loop struct {
+	locals    int // locals declared when the body starts
+	tries     int // try blocks entered when the body starts
+	breaks    []int
+	continues []int
+}
+
+// try is a try block whose handler is active.
+type try struct {
+	locals     int // locals declared when the block starts
+	hasFinally bool
+	finally    []ast.Stmt
+}
+
+// Locals records the references to local variables found by the resolver,
+// for compiling code that no VM has resolved.
+type Locals map[ast.Expr]int
+
+// Resolve records the scope depth of a reference. The resolver's slot is
+// ignored: the compiler lays out the stack slots of a function itself.
+func (l Locals) Resolve(expr ast.Expr, depth int, slot int) {
+	l[expr] = depth
+}
+
+// New returns a compiler for statements resolved into locals.
+func New(locals map[ast.Expr]int) *Compiler {
+	return &Compiler{locals: locals}
+}
+
+// Compile compiles the top-level statements of a program into a function
+// named name, which runs them and returns nil.
+func (c *Compiler) Compile(name string, stmts []ast.Stmt) (*bytecode.Function, error) {
+	c.beginFunction(kindScript, name, 0)
+	for _, stmt := range stmts {
+		c.statement(stmt)
+	}
+	c.emitReturn()
+	return c.endFunction(), c.errs.Err()
+}
+
+// CompileExpression compiles expr into a function named name returning its
+// value.
+func (c *Compiler) CompileExpression(name string, expr ast.Expr) (*bytecode.Function, error) {
+	c.beginFunction(kindScript, name, 0)
+	c.expression(expr)
+	c.emitOp(bytecode.OpReturn)
+	return c.endFunction(), c.errs.Err()
+}
+
+func (c *Compiler) statement(stmt ast.Stmt) {
+	c.span = stmt.Span()
+	stmt.Accept(c)
+}
+
+func (c *Compiler) expression(expr ast.Expr) {
+	expr.Accept(c)
+}
+
+// Stmt visitors
+func (c *Compiler) VisitPrintStmt(stmt *ast.PrintStmt) any {
+	c.expression(stmt.Expression)
+	c.emitOp(bytecode.OpPrint)
+	return nil
+}
+
+func (c *Compiler) VisitExpressionStmt(stmt *ast.ExpressionStmt) any {
+	c.expression(stmt.Expression)
+	c.emitOp(bytecode.OpPop)
+	return nil
+}
+
+func (c *Compiler) VisitVarStmt(stmt *ast.VarStmt) any {
+	if c.fn.depth > 0 && stmt.Initializer != nil {
+		c.localVar(stmt.Name, stmt.Initializer)
+		return nil
+	}
+
+	if stmt.Initializer != nil {
+		c.expression(stmt.Initializer)
+	} else {
+		c.emitOp(bytecode.OpNil)
+	}
+	c.at(stmt.Name)
+	c.defineVariable(stmt.Name.Lexeme())
+	return nil
+}
+
+func (c *Compiler) VisitBlockStmt(stmt *ast.BlockStmt) any {
+	c.block(stmt.Statements)
+	return nil
+}
+
+func (c *Compiler) VisitIfStmt(stmt *ast.IfStmt) any {
+	c.expression(stmt.Condition)
+	thenJump := c.emitJump(bytecode.OpJumpIfFalse)
+	c.emitOp(bytecode.OpPop)
+	c.statement(stmt.Then)
+
+	elseJump := c.emitJump(bytecode.OpJump)
+	c.patchJump(thenJump)
+	c.emitOp(bytecode.OpPop)
+	if stmt.Else != nil {
+		c.statement(stmt.Else)
+	}
+	c.patchJump(elseJump)
+	return nil
+}
+
+func (c *Compiler) VisitWhileStmt(stmt *ast.WhileStmt) any {
+	start := len(c.chunk().Code)
+	c.expression(stmt.Condition)
+	exitJump := c.emitJump(bytecode.OpJumpIfFalse)
+	c.emitOp(bytecode.OpPop)
+
+	l := c.beginLoop()
+	c.statement(stmt.Body)
+	c.endLoop()
+
+	c.patchJumps(l.continues)
+	if stmt.Increment != nil {
+		c.expression(stmt.Increment)
+		c.emitOp(bytecode.OpPop)
+	}
+	c.emitLoop(start)
+
+	c.patchJump(exitJump)
+	c.emitOp(bytecode.OpPop)
+	c.patchJumps(l.breaks)
+	return nil
+}
+
+func (c *Compiler) VisitForInStmt(stmt *ast.ForInStmt) any {
+	c.expression(stmt.Iterable)
+	c.at(stmt.Keyword)
+	c.emitOp(bytecode.OpIter)
+
+	// The iterator stays in a slot of its own for the whole loop.
+	c.beginScope()
+	c.addLocal("")
+
+	start := len(c.chunk().Code)
+	c.at(stmt.Keyword)
+	exitJump := c.emitJump(bytecode.OpIterNext)
+
+	// The loop variable is a new local in every iteration, so closures in
+	// the body capture that iteration's value.
+	l := c.beginLoop()
+	c.beginScope()
+	c.addLocal(stmt.Name.Lexeme())
+	c.statement(stmt.Body)
+	c.endScope()
+	c.endLoop()
+
+	c.patchJumps(l.continues)
+	c.emitLoop(start)
+
+	c.patchJump(exitJump)
+	c.patchJumps(l.breaks)
+	c.endScope()
+	return nil
+}
+
+func (c *Compiler) VisitBreakStmt(stmt *ast.BreakStmt) any {
+	l := c.fn.loops[len(c.fn.loops)-1]
+	c.exitTries(l.tries)
+	c.at(stmt.Keyword)
+	c.popLocals(l.locals)
+	l.breaks = append(l.breaks, c.emitJump(bytecode.OpJump))
+	return nil
+}
+
+func (c *Compiler) VisitContinueStmt(stmt *ast.ContinueStmt) any {
+	l := c.fn.loops[len(c.fn.loops)-1]
+	c.exitTries(l.tries)
+	c.at(stmt.Keyword)
+	c.popLocals(l.locals)
+	l.continues = append(l.continues, c.emitJump(bytecode.OpJump))
+	return nil
+}
+
+func (c *Compiler) VisitFunctionStmt(stmt *ast.FunctionStmt) any {
+	name := stmt.Name.Lexeme()
+	c.at(stmt.Name)
+	// A local function is in scope in its own body, so it can recurse.
+	if c.fn.depth > 0 {
+		c.addLocal(name)
+		c.function(kindFunction, name, stmt.Params, stmt.Body)
+		return nil
+	}
+
+	c.function(kindFunction, name, stmt.Params, stmt.Body)
+	c.emitShort(bytecode.OpDefineGlobal, c.constant(name))
+	return nil
+}
+
+func (c *Compiler) VisitReturnStmt(stmt *ast.ReturnStmt) any {
+	if stmt.Value != nil {
+		c.expression(stmt.Value)
+	} else {
+		c.at(stmt.KeyWord)
+		c.emitImplicitValue()
+	}
+
+	if len(c.fn.tries) > 0 {
+		// Hold the value in a slot while the finally clauses run.
+		c.addLocal("")
+		c.exitTries(0)
+		c.fn.locals = c.fn.locals[:len(c.fn.locals)-1]
+	}
+	c.at(stmt.KeyWord)
+	c.emitOp(bytecode.OpReturn)
+	return nil
+}
+
+func (c *Compiler) VisitThrowStmt(stmt *ast.ThrowStmt) any {
+	c.expression(stmt.Value)
+	c.at(stmt.Keyword)
+	c.emitOp(bytecode.OpThrow)
+	return nil
+}
+
+// VisitTryStmt compiles the try block under a handler for each clause. A
+// caught error jumps to the catch clause, whose handler has been removed,
+// and an error escaping the try and catch clauses jumps to a copy of the
+// finally clause that raises it again.
+func (c *Compiler) VisitTryStmt(stmt *ast.TryStmt) any {
+	var finallyHandler, catchHandler int
+	c.at(stmt.Keyword)
+	if stmt.HasFinally {
+		finallyHandler = c.emitJump(bytecode.OpTry)
+		c.beginTry(true, stmt.Finally)
+	}
+	if stmt.CatchName != nil {
+		catchHandler = c.emitJump(bytecode.OpTry)
+		c.beginTry(false, nil)
+	}
+
+	c.block(stmt.Body)
+
+	if stmt.CatchName != nil {
+		c.endTry()
+		c.at(stmt.Keyword)
+		c.emitOp(bytecode.OpEndTry)
+		skipCatch := c.emitJump(bytecode.OpJump)
+
+		c.patchJump(catchHandler)
+		c.beginScope()
+		c.at(stmt.CatchName)
+		c.emitOp(bytecode.OpErrorValue)
+		c.addLocal(stmt.CatchName.Lexeme())
+		for _, s := range stmt.Catch {
+			c.statement(s)
+		}
+		c.endScope()
+		c.patchJump(skipCatch)
+	}
+
+	if stmt.HasFinally {
+		c.endTry()
+		c.at(stmt.Keyword)
+		c.emitOp(bytecode.OpEndTry)
+		c.block(stmt.Finally)
+		done := c.emitJump(bytecode.OpJump)
+
+		// The handler runs the clause with the error in a slot, then raises
+		// it again. The slot is never popped: OpRethrow leaves the block.
+		c.patchJump(finallyHandler)
+		c.addLocal("")
+		c.block(stmt.Finally)
+		c.at(stmt.Keyword)
+		c.emitOp(bytecode.OpRethrow)
+		c.fn.locals = c.fn.locals[:len(c.fn.locals)-1]
+
+		c.patchJump(done)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitClassStmt(stmt *ast.ClassStmt) any {
+	name := stmt.Name.Lexeme()
+	isLocal := c.fn.depth > 0
+
+	// A local class gets its slot first, below the superclass. A global one
+	// is declared once the superclass is checked, like in the interpreter.
+	if isLocal {
+		c.at(stmt.Name)
+		c.emitOp(bytecode.OpNil)
+		c.addLocal(name)
+	}
+
+	op := bytecode.OpClass
+	if stmt.SuperClass != nil {
+		op = bytecode.OpSubclass
+		c.expression(stmt.SuperClass)
+		c.at(stmt.SuperClass.Name)
+		c.emitOp(bytecode.OpSuperclass)
+	}
+
+	if !isLocal {
+		c.at(stmt.Name)
+		c.emitOp(bytecode.OpNil)
+		c.emitShort(bytecode.OpDefineGlobal, c.constant(name))
+	}
+
+	if stmt.SuperClass != nil {
+		// Methods reach the superclass through a "super" variable
+		// enclosing them.
+		c.beginScope()
+		c.addLocal("super")
+	}
+
+	for _, method := range stmt.Methods {
+		kind := kindMethod
+		if method.Name.Lexeme() == "init" {
+			kind = kindInitializer
+		}
+		c.at(method.Name)
+		c.function(kind, method.Name.Lexeme(), method.Params, method.Body)
+	}
+
+	c.at(stmt.Name)
+	c.emitShort(op, c.constant(name))
+	c.emitOperand(len(stmt.Methods), "Too many methods in one class.")
+	c.assignVariable(name, isLocal)
+	c.emitOp(bytecode.OpPop)
+
+	if stmt.SuperClass != nil {
+		c.endScope()
+	}
+	return nil
+}
+
+func (c *Compiler) VisitImportStmt(stmt *ast.ImportStmt) any {
+	c.at(stmt.Path)
+	c.emitShort(bytecode.OpImport, c.constant(stmt.Path.Literal().(string)))
+
+	// Imports are only allowed at the top level, so they define globals.
+	if stmt.Alias != nil {
+		c.at(stmt.Alias)
+		c.emitShort(bytecode.OpDefineGlobal, c.constant(stmt.Alias.Lexeme()))
+		return nil
+	}
+	for _, name := range stmt.Names {
+		c.at(name)
+		c.emitOp(bytecode.OpDup)
+		c.emitShort(bytecode.OpGetProperty, c.constant(name.Lexeme()))
+		c.emitShort(bytecode.OpDefineGlobal, c.constant(name.Lexeme()))
+	}
+	c.emitOp(bytecode.OpPop)
+	return nil
+}
+
+// Expr visitors
+func (c *Compiler) VisitLiteralExpr(expr *ast.LiteralExpr) any {
+	switch v := expr.Val.(type) {
+	case nil:
+		c.emitOp(bytecode.OpNil)
+	case bool:
+		if v {
+			c.emitOp(bytecode.OpTrue)
+		} else {
+			c.emitOp(bytecode.OpFalse)
+		}
+	default:
+		c.emitShort(bytecode.OpConstant, c.constant(v))
+	}
+	return nil
+}
+
+func (c *Compiler) VisitGroupingExpr(expr *ast.GroupingExpr) any {
+	c.expression(expr.Expression)
+	return nil
+}
+
+func (c *Compiler) VisitUnaryExpr(expr *ast.UnaryExpr) any {
+	c.expression(expr.Right)
+	c.at(&expr.Op)
+	switch expr.Op.Type() {
+	case token.BANG:
+		c.emitOp(bytecode.OpNot)
+	case token.MINUS:
+		c.emitOp(bytecode.OpNegate)
+	}
+	return nil
+}
+
+var binaryOps = map[token.Type]bytecode.OpCode{
+	token.MINUS:         bytecode.OpSubtract,
+	token.SLASH:         bytecode.OpDivide,
+	token.STAR:          bytecode.OpMultiply,
+	token.PLUS:          bytecode.OpAdd,
+	token.GREATER:       bytecode.OpGreater,
+	token.GREATER_EQUAL: bytecode.OpGreaterEqual,
+	token.LESS:          bytecode.OpLess,
+	token.LESS_EQUAL:    bytecode.OpLessEqual,
+	token.BANG_EQUAL:    bytecode.OpNotEqual,
+	token.EQUAL_EQUAL:   bytecode.OpEqual,
+}
+
+func (c *Compiler) VisitBinaryExpr(expr *ast.BinaryExpr) any {
+	c.expression(expr.Left)
+	c.expression(expr.Right)
+	c.at(&expr.Op)
+	c.emitOp(binaryOps[expr.Op.Type()])
+	return nil
+}
+
+func (c *Compiler) VisitVariableExpr(expr *ast.VariableExpr) any {
+	c.at(expr.Name)
+	c.getVariable(expr, expr.Name.Lexeme())
+	return nil
+}
+
+func (c *Compiler) VisitAssignExpr(expr *ast.AssignExpr) any {
+	c.expression(expr.Value)
+	c.at(expr.Name)
+	_, isLocal := c.locals[expr]
+	c.assignVariable(expr.Name.Lexeme(), isLocal)
+	return nil
+}
+
+// VisitLogicalExpr jumps over the right operand when the left one decides
+// the result, which is then left on the stack.
+func (c *Compiler) VisitLogicalExpr(expr *ast.LogicalExpr) any {
+	c.expression(expr.Left)
+	if expr.Operator.Type() == token.OR {
+		elseJump := c.emitJump(bytecode.OpJumpIfFalse)
+		endJump := c.emitJump(bytecode.OpJump)
+		c.patchJump(elseJump)
+		c.emitOp(bytecode.OpPop)
+		c.expression(expr.Right)
+		c.patchJump(endJump)
+		return nil
+	}
+
+	endJump := c.emitJump(bytecode.OpJumpIfFalse)
+	c.emitOp(bytecode.OpPop)
+	c.expression(expr.Right)
+	c.patchJump(endJump)
+	return nil
+}
+
+func (c *Compiler) VisitCallExpr(expr *ast.CallExpr) any {
+	// A method call does not create a bound method.
+	if get, ok := expr.Callee.(*ast.GetExpr); ok {
+		c.expression(get.Object)
+		c.arguments(expr.Arguments)
+		c.at(expr.Paren)
+		c.emitShort(bytecode.OpInvoke, c.constant(get.Name.Lexeme()))
+		c.emitByte(byte(len(expr.Arguments)))
+		return nil
+	}
+
+	c.expression(expr.Callee)
+	c.arguments(expr.Arguments)
+	c.at(expr.Paren)
+	c.emitOp(bytecode.OpCall)
+	c.emitByte(byte(len(expr.Arguments)))
+	return nil
+}
+
+// arguments compiles the arguments of a call. The parser allows at most 255
+// of them, so their count fits the operand.
+func (c *Compiler) arguments(args []ast.Expr) {
+	for _, arg := range args {
+		c.expression(arg)
+	}
+}
+
+func (c *Compiler) VisitGetExpr(expr *ast.GetExpr) any {
+	c.expression(expr.Object)
+	c.at(expr.Name)
+	c.emitShort(bytecode.OpGetProperty, c.constant(expr.Name.Lexeme()))
+	return nil
+}
+
+func (c *Compiler) VisitSetExpr(expr *ast.SetExpr) any {
+	c.expression(expr.Object)
+	c.expression(expr.Value)
+	c.at(expr.Name)
+	c.emitShort(bytecode.OpSetProperty, c.constant(expr.Name.Lexeme()))
+	return nil
+}
+
+func (c *Compiler) VisitThisExpr(expr *ast.ThisExpr) any {
+	c.at(expr.Keyword)
+	c.getVariable(expr, "this")
+	return nil
+}
+
+func (c *Compiler) VisitSuperExpr(expr *ast.SuperExpr) any {
+	c.at(expr.Keyword)
+	c.namedVariable("this")
+	c.namedVariable("super")
+	c.at(expr.Method)
+	c.emitShort(bytecode.OpGetSuper, c.constant(expr.Method.Lexeme()))
+	return nil
+}
+
+func (c *Compiler) VisitFunctionExpr(expr *ast.FunctionExpr) any {
+	c.at(expr.Keyword)
+	c.function(kindFunction, anonymousName, expr.Params, expr.Body)
+	return nil
+}
+
+func (c *Compiler) VisitListExpr(expr *ast.ListExpr) any {
+	for _, element := range expr.Elements {
+		c.expression(element)
+	}
+	c.span = expr.Span()
+	c.emitOp(bytecode.OpList)
+	c.emitOperand(len(expr.Elements), "Too many elements in a list literal.")
+	return nil
+}
+
+func (c *Compiler) VisitMapExpr(expr *ast.MapExpr) any {
+	for n, key := range expr.Keys {
+		c.expression(key)
+		c.expression(expr.Values[n])
+	}
+	c.at(expr.Brace)
+	c.emitOp(bytecode.OpMap)
+	c.emitOperand(len(expr.Keys), "Too many entries in a map literal.")
+	return nil
+}
+
+func (c *Compiler) VisitIndexExpr(expr *ast.IndexExpr) any {
+	c.expression(expr.Object)
+	c.expression(expr.Index)
+	c.at(expr.Bracket)
+	c.emitOp(bytecode.OpGetIndex)
+	return nil
+}
+
+func (c *Compiler) VisitIndexSetExpr(expr *ast.IndexSetExpr) any {
+	c.expression(expr.Object)
+	c.expression(expr.Index)
+	c.expression(expr.Value)
+	c.at(expr.Bracket)
+	c.emitOp(bytecode.OpSetIndex)
+	return nil
+}
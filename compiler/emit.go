@@ -0,0 +1,119 @@
+package compiler
+
+import (
+	"lox/bytecode"
+	"lox/loxerr"
+	"lox/token"
+)
+
+func (c *Compiler) chunk() *bytecode.Chunk {
+	return &c.fn.proto.Chunk
+}
+
+// at makes the following instructions report errors at tok.
+func (c *Compiler) at(tok *token.Token) {
+	c.span = tok.Span()
+}
+
+func (c *Compiler) error(msg string) {
+	c.errs = append(c.errs, &loxerr.CompileError{Position: c.span.Start, End: c.span.End, Msg: msg})
+}
+
+func (c *Compiler) emitByte(b byte) {
+	c.chunk().Write(b, c.span)
+}
+
+func (c *Compiler) emitOp(op bytecode.OpCode) {
+	c.emitByte(byte(op))
+}
+
+// emitOperand appends a two-byte operand, reporting msg when n does not fit.
+func (c *Compiler) emitOperand(n int, msg string) {
+	if n > maxOperand {
+		c.error(msg)
+	}
+	c.emitByte(byte(n >> 8))
+	c.emitByte(byte(n))
+}
+
+// emitShort appends an instruction with a two-byte operand that has been
+// checked already.
+func (c *Compiler) emitShort(op bytecode.OpCode, operand int) {
+	c.emitOp(op)
+	c.emitOperand(operand, "")
+}
+
+// emitJump appends a forward jump and returns the offset of its operand
+// for patchJump.
+func (c *Compiler) emitJump(op bytecode.OpCode) int {
+	c.emitOp(op)
+	c.emitByte(0xff)
+	c.emitByte(0xff)
+	return len(c.chunk().Code) - 2
+}
+
+// patchJump makes the jump whose operand is at offset land on the next
+// instruction.
+func (c *Compiler) patchJump(offset int) {
+	code := c.chunk().Code
+	jump := len(code) - offset - 2
+	if jump > maxOperand {
+		c.error("Too much code to jump over.")
+	}
+	code[offset] = byte(jump >> 8)
+	code[offset+1] = byte(jump)
+}
+
+func (c *Compiler) patchJumps(offsets []int) {
+	for _, offset := range offsets {
+		c.patchJump(offset)
+	}
+}
+
+// emitLoop appends a backward jump to start.
+func (c *Compiler) emitLoop(start int) {
+	c.emitOp(bytecode.OpLoop)
+	offset := len(c.chunk().Code) - start + 2
+	if offset > maxOperand {
+		c.error("Loop body too large.")
+	}
+	c.emitByte(byte(offset >> 8))
+	c.emitByte(byte(offset))
+}
+
+// emitImplicitValue pushes what a function returns without a value: the
+// instance for an initializer, nil otherwise.
+func (c *Compiler) emitImplicitValue() {
+	if c.fn.kind == kindInitializer {
+		c.emitShort(bytecode.OpGetLocal, 0)
+	} else {
+		c.emitOp(bytecode.OpNil)
+	}
+}
+
+func (c *Compiler) emitReturn() {
+	c.emitImplicitValue()
+	c.emitOp(bytecode.OpReturn)
+}
+
+// constant returns the index of v in the constant pool, adding it unless an
+// equal number or string is there already.
+func (c *Compiler) constant(v any) int {
+	switch v.(type) {
+	case float64, string:
+		if n, ok := c.fn.constants[v]; ok {
+			return n
+		}
+	}
+
+	n := c.chunk().AddConstant(v)
+	if n > maxOperand {
+		c.error("Too many constants in one function.")
+		return 0
+	}
+	switch v.(type) {
+	case float64, string:
+		c.fn.constants[v] = n
+	}
+	return n
+}
@@ -0,0 +1,307 @@
+package compiler
+
+import (
+	"lox/ast"
+	"lox/bytecode"
+	"lox/token"
+)
+
+// beginFunction starts compiling a function with arity parameters. Slot 0
+// holds the instance in methods and the called closure otherwise.
+func (c *Compiler) beginFunction(kind functionKind, name string, arity int) {
+	fn := &function{
+		enclosing: c.fn,
+		proto:     &bytecode.Function{Name: name, Arity: arity},
+		kind:      kind,
+		constants: map[any]int{},
+	}
+	slot0 := ""
+	if kind == kindMethod || kind == kindInitializer {
+		slot0 = "this"
+	}
+	fn.locals = append(fn.locals, local{name: slot0})
+	c.fn = fn
+}
+
+func (c *Compiler) endFunction() *bytecode.Function {
+	fn := c.fn
+	fn.proto.UpvalueCount = len(fn.upvalues)
+	c.fn = fn.enclosing
+	return fn.proto
+}
+
+// function compiles a function body and emits the closure creating it.
+func (c *Compiler) function(kind functionKind, name string, params []*token.Token, body []ast.Stmt) {
+	span := c.span
+	c.beginFunction(kind, name, len(params))
+	c.beginScope()
+	for _, param := range params {
+		c.at(param)
+		c.addLocal(param.Lexeme())
+	}
+	for _, stmt := range body {
+		c.statement(stmt)
+	}
+	c.emitReturn()
+	fn := c.fn
+	proto := c.endFunction()
+
+	c.span = span
+	c.emitShort(bytecode.OpClosure, c.constant(proto))
+	for _, up := range fn.upvalues {
+		if up.isLocal {
+			c.emitByte(1)
+		} else {
+			c.emitByte(0)
+		}
+		c.emitOperand(up.index, "")
+	}
+}
+
+func (c *Compiler) block(stmts []ast.Stmt) {
+	c.beginScope()
+	for _, stmt := range stmts {
+		c.statement(stmt)
+	}
+	c.endScope()
+}
+
+func (c *Compiler) beginScope() {
+	c.fn.depth++
+}
+
+// endScope discards the locals of the innermost scope, closing over those
+// captured by closures.
+func (c *Compiler) endScope() {
+	fn := c.fn
+	fn.depth--
+	for len(fn.locals) > 0 && fn.locals[len(fn.locals)-1].depth > fn.depth {
+		if fn.locals[len(fn.locals)-1].captured {
+			c.emitOp(bytecode.OpCloseUpvalue)
+		} else {
+			c.emitOp(bytecode.OpPop)
+		}
+		fn.locals = fn.locals[:len(fn.locals)-1]
+	}
+}
+
+// popLocals discards the locals above the first n, leaving them declared
+// for the code that follows a jump out of their scope.
+func (c *Compiler) popLocals(n int) {
+	for i := len(c.fn.locals) - 1; i >= n; i-- {
+		if c.fn.locals[i].captured {
+			c.emitOp(bytecode.OpCloseUpvalue)
+		} else {
+			c.emitOp(bytecode.OpPop)
+		}
+	}
+}
+
+// addLocal declares the value on top of the stack as a local of the
+// current scope.
+func (c *Compiler) addLocal(name string) {
+	fn := c.fn
+	if len(fn.locals) > maxOperand {
+		c.error("Too many local variables in function.")
+	}
+	fn.locals = append(fn.locals, local{name: name, depth: fn.depth})
+}
+
+// defineVariable binds name to the value on top of the stack: in a new
+// local inside a scope, in a global at the top level.
+func (c *Compiler) defineVariable(name string) {
+	if c.fn.depth > 0 {
+		c.addLocal(name)
+		return
+	}
+	c.emitShort(bytecode.OpDefineGlobal, c.constant(name))
+}
+
+// localVar declares the local name before compiling its initializer, so
+// that the functions in the initializer can capture it. Like in the
+// tree-walker, it holds nil until the initializer is done, when one of those
+// functions might run before. A lone function expression cannot, so its
+// closure goes straight into the slot, as does any initializer without
+// functions.
+func (c *Compiler) localVar(name *token.Token, initializer ast.Expr) {
+	c.at(name)
+	if _, ok := initializer.(*ast.FunctionExpr); ok || !hasFunction(initializer) {
+		c.addLocal(name.Lexeme())
+		c.expression(initializer)
+		return
+	}
+
+	c.emitOp(bytecode.OpNil)
+	c.addLocal(name.Lexeme())
+	c.expression(initializer)
+	c.at(name)
+	c.emitShort(bytecode.OpSetLocal, len(c.fn.locals)-1)
+	c.emitOp(bytecode.OpPop)
+}
+
+// hasFunction reports whether expr contains a function expression.
+func hasFunction(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.LiteralExpr, *ast.VariableExpr, *ast.ThisExpr, *ast.SuperExpr:
+		return false
+	case *ast.FunctionExpr:
+		return true
+	case *ast.UnaryExpr:
+		return hasFunction(e.Right)
+	case *ast.BinaryExpr:
+		return hasFunction(e.Left) || hasFunction(e.Right)
+	case *ast.LogicalExpr:
+		return hasFunction(e.Left) || hasFunction(e.Right)
+	case *ast.GroupingExpr:
+		return hasFunction(e.Expression)
+	case *ast.AssignExpr:
+		return hasFunction(e.Value)
+	case *ast.GetExpr:
+		return hasFunction(e.Object)
+	case *ast.SetExpr:
+		return hasFunction(e.Object) || hasFunction(e.Value)
+	case *ast.IndexExpr:
+		return hasFunction(e.Object) || hasFunction(e.Index)
+	case *ast.IndexSetExpr:
+		return hasFunction(e.Object) || hasFunction(e.Index) || hasFunction(e.Value)
+	case *ast.CallExpr:
+		return hasFunction(e.Callee) || anyFunction(e.Arguments)
+	case *ast.ListExpr:
+		return anyFunction(e.Elements)
+	case *ast.MapExpr:
+		return anyFunction(e.Keys) || anyFunction(e.Values)
+	}
+	return true
+}
+
+func anyFunction(exprs []ast.Expr) bool {
+	for _, expr := range exprs {
+		if hasFunction(expr) {
+			return true
+		}
+	}
+	return false
+}
+
+// getVariable pushes the variable name read by expr.
+func (c *Compiler) getVariable(expr ast.Expr, name string) {
+	if _, ok := c.locals[expr]; ok {
+		c.namedVariable(name)
+		return
+	}
+	c.emitShort(bytecode.OpGetGlobal, c.constant(name))
+}
+
+// namedVariable pushes the local or captured variable name.
+func (c *Compiler) namedVariable(name string) {
+	if slot := resolveLocal(c.fn, name); slot >= 0 {
+		c.emitShort(bytecode.OpGetLocal, slot)
+	} else if index := c.resolveUpvalue(c.fn, name); index >= 0 {
+		c.emitShort(bytecode.OpGetUpvalue, index)
+	} else {
+		c.emitShort(bytecode.OpGetGlobal, c.constant(name))
+	}
+}
+
+// assignVariable assigns the value on top of the stack to the variable
+// name, leaving it there.
+func (c *Compiler) assignVariable(name string, isLocal bool) {
+	if isLocal {
+		if slot := resolveLocal(c.fn, name); slot >= 0 {
+			c.emitShort(bytecode.OpSetLocal, slot)
+			return
+		}
+		if index := c.resolveUpvalue(c.fn, name); index >= 0 {
+			c.emitShort(bytecode.OpSetUpvalue, index)
+			return
+		}
+	}
+	c.emitShort(bytecode.OpSetGlobal, c.constant(name))
+}
+
+// resolveLocal returns the slot of the innermost local of fn called name,
+// or -1.
+func resolveLocal(fn *function, name string) int {
+	for i := len(fn.locals) - 1; i >= 0; i-- {
+		if l := fn.locals[i]; l.name == name && !l.hidden {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolveUpvalue returns the index of the upvalue through which fn reaches
+// the variable name of an enclosing function, or -1.
+func (c *Compiler) resolveUpvalue(fn *function, name string) int {
+	if fn.enclosing == nil {
+		return -1
+	}
+	if slot := resolveLocal(fn.enclosing, name); slot >= 0 {
+		fn.enclosing.locals[slot].captured = true
+		return c.addUpvalue(fn, slot, true)
+	}
+	if index := c.resolveUpvalue(fn.enclosing, name); index >= 0 {
+		return c.addUpvalue(fn, index, false)
+	}
+	return -1
+}
+
+func (c *Compiler) addUpvalue(fn *function, index int, isLocal bool) int {
+	for i, up := range fn.upvalues {
+		if up.index == index && up.isLocal == isLocal {
+			return i
+		}
+	}
+	if len(fn.upvalues) > maxOperand {
+		c.error("Too many closure variables in function.")
+		return 0
+	}
+	fn.upvalues = append(fn.upvalues, upvalue{index: index, isLocal: isLocal})
+	return len(fn.upvalues) - 1
+}
+
+func (c *Compiler) beginLoop() *loop {
+	l := &loop{locals: len(c.fn.locals), tries: len(c.fn.tries)}
+	c.fn.loops = append(c.fn.loops, l)
+	return l
+}
+
+func (c *Compiler) endLoop() {
+	c.fn.loops = c.fn.loops[:len(c.fn.loops)-1]
+}
+
+func (c *Compiler) beginTry(hasFinally bool, finally []ast.Stmt) {
+	c.fn.tries = append(c.fn.tries, try{locals: len(c.fn.locals), hasFinally: hasFinally, finally: finally})
+}
+
+func (c *Compiler) endTry() {
+	c.fn.tries = c.fn.tries[:len(c.fn.tries)-1]
+}
+
+// exitTries emits the code jumping out of the try blocks entered after the
+// first n: it removes their handlers and runs their finally clauses, from
+// the innermost out. A finally clause is compiled where its try statement
+// is, so the locals declared inside the try block are hidden from it.
+func (c *Compiler) exitTries(n int) {
+	fn := c.fn
+	tries := fn.tries
+	for i := len(tries) - 1; i >= n; i-- {
+		t := tries[i]
+		c.emitOp(bytecode.OpEndTry)
+		if !t.hasFinally {
+			continue
+		}
+
+		fn.tries = tries[:i]
+		hidden := make([]bool, len(fn.locals)-t.locals)
+		for j := range hidden {
+			hidden[j] = fn.locals[t.locals+j].hidden
+			fn.locals[t.locals+j].hidden = true
+		}
+		c.block(t.finally)
+		for j, h := range hidden {
+			fn.locals[t.locals+j].hidden = h
+		}
+	}
+	fn.tries = tries
+}
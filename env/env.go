@@ -5,24 +5,54 @@ import (
 	"lox/token"
 )
 
+// Env holds the variables of one scope. A global scope finds them by name.
+// A local scope, made for a block or a call, keeps them in slots numbered
+// in the order they are declared, as assigned by the resolver.
 type Env struct {
-	values    map[string]any
+	values    []any          // of a local scope, by slot
+	names     map[string]any // of a global scope
 	enclosing *Env
 }
 
-func New(encolsing *Env) *Env {
+// New returns a local scope enclosed by enclosing.
+func New(enclosing *Env) *Env {
+	return &Env{enclosing: enclosing}
+}
+
+// NewGlobal returns a global scope, enclosed by enclosing if not nil.
+func NewGlobal(enclosing *Env) *Env {
 	return &Env{
-		values:    map[string]any{},
-		enclosing: encolsing,
+		names:     map[string]any{},
+		enclosing: enclosing,
 	}
 }
 
+// Define defines the variable name in a global scope, or gives it the next
+// slot in a local scope.
 func (e *Env) Define(name string, val any) {
-	e.values[name] = val
+	if e.names != nil {
+		e.names[name] = val
+		return
+	}
+	e.values = append(e.values, val)
+}
+
+// Declare gives the next slot of a local scope to a variable holding nil,
+// and returns the slot. It returns false for a global scope.
+func (e *Env) Declare() (int, bool) {
+	if e.names != nil {
+		return 0, false
+	}
+	e.values = append(e.values, nil)
+	return len(e.values) - 1, true
 }
 
-func (e *Env) GetAt(distance int, name string) any {
-	return e.ancestor(distance).values[name]
+func (e *Env) GetAt(distance int, slot int) any {
+	return e.ancestor(distance).values[slot]
+}
+
+func (e *Env) AssignAt(distance int, slot int, val any) {
+	e.ancestor(distance).values[slot] = val
 }
 
 func (e *Env) Enclosing() *Env {
@@ -37,8 +67,17 @@ func (e *Env) ancestor(distance int) *Env {
 	return env
 }
 
+// Lookup returns the value of name defined directly in the global scope e,
+// without looking at enclosing environments.
+func (e *Env) Lookup(name string) (any, bool) {
+	val, has := e.names[name]
+	return val, has
+}
+
+// Get returns the value of the global variable token, looking through e
+// and the scopes enclosing it.
 func (e *Env) Get(token *token.Token) (any, error) {
-	if val, has := e.values[token.Lexeme()]; has {
+	if val, has := e.names[token.Lexeme()]; has {
 		return val, nil
 	}
 
@@ -46,12 +85,12 @@ func (e *Env) Get(token *token.Token) (any, error) {
 		return e.enclosing.Get(token)
 	}
 
-	return nil, fmt.Errorf("Get: Undefined variable '%s'", token.Lexeme())
+	return nil, fmt.Errorf("Undefined variable '%s'.", token.Lexeme())
 }
 
 func (e *Env) Assign(name *token.Token, val any) error {
-	if _, has := e.values[name.Lexeme()]; has {
-		e.values[name.Lexeme()] = val
+	if _, has := e.names[name.Lexeme()]; has {
+		e.names[name.Lexeme()] = val
 		return nil
 	}
 
@@ -59,11 +98,5 @@ func (e *Env) Assign(name *token.Token, val any) error {
 		return e.enclosing.Assign(name, val)
 	}
 
-	return fmt.Errorf("Assign: Undefined variable '%s'", name.Lexeme())
-}
-
-func (e *Env) AssignAt(distance int, name *token.Token, val any) error {
-	env := e.ancestor(distance)
-	env.values[name.Lexeme()] = val
-	return nil
+	return fmt.Errorf("Undefined variable '%s'.", name.Lexeme())
 }
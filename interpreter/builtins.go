@@ -0,0 +1,133 @@
+package interpreter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"lox/env"
+	"os"
+	"strings"
+	"time"
+)
+
+// Host holds what the tree-walking Interpreter and the bytecode VM share
+// with the program embedding them: the built-in functions, the globals of
+// the main script and the I/O streams. Both embed it, so that they define
+// the same built-ins.
+type Host struct {
+	builtins *env.Env // shared by the main script and every module
+	main     *env.Env // globals of the main script
+	stdout   io.Writer
+	stderr   io.Writer
+	stdin    *bufio.Reader
+}
+
+// NewHost returns a host with its own global environment, enclosed by the
+// environment of the built-in functions. Print statements write to
+// os.Stdout, and the I/O built-ins use os.Stderr and os.Stdin.
+func NewHost() *Host {
+	builtins := env.NewGlobal(nil)
+	h := &Host{
+		builtins: builtins,
+		main:     env.NewGlobal(builtins),
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+		stdin:    bufio.NewReader(os.Stdin),
+	}
+	h.defineBuiltins()
+
+	return h
+}
+
+// defineBuiltins defines the native functions every program can use.
+func (h *Host) defineBuiltins() {
+	h.DefineBuiltin(errorClassName, errorClass)
+	h.DefineNative("clock", 0, Clock)
+
+	// printErr(value) prints value to the error output, like print does to
+	// the standard output.
+	h.DefineNative("printErr", 1, func(args []any) (any, error) {
+		_, err := fmt.Fprintln(h.stderr, Stringify(args[0]))
+		return nil, err
+	})
+
+	h.DefineNative("readLine", 0, func(args []any) (any, error) {
+		return ReadLine(h.stdin)
+	})
+}
+
+// Builtins returns the environment of the built-ins, which encloses the
+// globals of the main script and of every module.
+func (h *Host) Builtins() *env.Env {
+	return h.builtins
+}
+
+// Main returns the global environment of the main script.
+func (h *Host) Main() *env.Env {
+	return h.main
+}
+
+// Stdout returns where print statements write.
+func (h *Host) Stdout() io.Writer {
+	return h.stdout
+}
+
+// SetStdout redirects the output of print statements to w.
+func (h *Host) SetStdout(w io.Writer) {
+	h.stdout = w
+}
+
+// SetStderr redirects the output of printErr to w.
+func (h *Host) SetStderr(w io.Writer) {
+	h.stderr = w
+}
+
+// SetStdin makes readLine read from r.
+func (h *Host) SetStdin(r io.Reader) {
+	h.stdin = bufio.NewReader(r)
+}
+
+// DefineGlobal defines, or redefines, the global variable name of the main
+// script.
+func (h *Host) DefineGlobal(name string, val any) {
+	h.main.Define(name, val)
+}
+
+// DefineBuiltin defines name for the main script and every module.
+func (h *Host) DefineBuiltin(name string, val any) {
+	h.builtins.Define(name, val)
+}
+
+// DefineNative defines fn as the built-in native function name.
+func (h *Host) DefineNative(name string, arity int, fn NativeFunc) {
+	h.DefineBuiltin(name, NewNative(name, arity, fn))
+}
+
+// GetGlobal returns the value of the global variable or built-in name, as
+// seen by the main script.
+func (h *Host) GetGlobal(name string) (any, bool) {
+	if val, ok := h.main.Lookup(name); ok {
+		return val, true
+	}
+	return h.builtins.Lookup(name)
+}
+
+// Clock implements clock(), returning the time in seconds.
+func Clock(args []any) (any, error) {
+	return float64(time.Now().UnixNano()) / float64(time.Second), nil
+}
+
+// ReadLine implements readLine(), returning the next line of r without its
+// line ending, or nil at the end of the input.
+func ReadLine(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF {
+		if line == "" {
+			return nil, nil
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
@@ -1,7 +1,23 @@
 package interpreter
 
+// Runtime runs Lox code: the tree-walking Interpreter or the bytecode VM.
+// Values implemented in Go, such as lists, call back into it to run the Lox
+// functions they are given.
+type Runtime interface {
+	// Callback calls callee with args. It fails when callee takes another
+	// number of arguments; runtime errors raised by callee unwind as panics.
+	Callback(callee Callable, args ...any) (any, error)
+}
+
 type Callable interface {
-	Call(interpreter *Interpreter, arguments []any) any
+	Call(rt Runtime, arguments []any) any
 	Arity() int
 	String() string
 }
+
+// Method is a function declared in a class. Reading it from an instance
+// binds it to the instance.
+type Method interface {
+	Arity() int
+	Bind(ins *Instance) Callable
+}
@@ -5,10 +5,10 @@ var _ Callable = (*Class)(nil)
 type Class struct {
 	superClass *Class
 	name       string
-	methods    map[string]*Function
+	methods    map[string]Method
 }
 
-func NewClass(name string, methods map[string]*Function, superClass *Class) *Class {
+func NewClass(name string, methods map[string]Method, superClass *Class) *Class {
 	return &Class{
 		name:       name,
 		methods:    methods,
@@ -16,6 +16,10 @@ func NewClass(name string, methods map[string]*Function, superClass *Class) *Cla
 	}
 }
 
+func (c *Class) Name() string {
+	return c.name
+}
+
 func (c *Class) Arity() int {
 	initalizer := c.FindMethod("init")
 	if initalizer == nil {
@@ -25,11 +29,11 @@ func (c *Class) Arity() int {
 	return initalizer.Arity()
 }
 
-func (c *Class) Call(interpreter *Interpreter, arguments []any) any {
+func (c *Class) Call(rt Runtime, arguments []any) any {
 	instance := NewInstance(c)
 	initMethod := c.FindMethod("init")
 	if initMethod != nil {
-		initMethod.Bind(instance).Call(interpreter, arguments)
+		initMethod.Bind(instance).Call(rt, arguments)
 	}
 	return instance
 }
@@ -38,7 +42,17 @@ func (c *Class) String() string {
 	return c.name
 }
 
-func (c *Class) FindMethod(name string) *Function {
+// IsSubclassOf reports whether c is other or inherits from it.
+func (c *Class) IsSubclassOf(other *Class) bool {
+	for ; c != nil; c = c.superClass {
+		if c == other {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Class) FindMethod(name string) Method {
 	if val, ok := c.methods[name]; ok {
 		return val
 	}
@@ -0,0 +1,26 @@
+package interpreter
+
+// completionKind says why a statement stopped running.
+type completionKind int
+
+const (
+	returnCompletion completionKind = iota
+	breakCompletion
+	continueCompletion
+)
+
+// break and continue carry no value, so they share one completion each.
+var (
+	breakSignal    = &completion{kind: breakCompletion}
+	continueSignal = &completion{kind: continueCompletion}
+)
+
+// completion signals a statement that jumped out of the code around it
+// instead of running to its end. A nil *completion is a normal completion.
+// It travels up through the enclosing blocks and loops as a return value
+// until the statement that handles it: a function call for a return, the
+// innermost loop for a break or continue.
+type completion struct {
+	kind  completionKind
+	value any // the value of a return
+}
@@ -0,0 +1,156 @@
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"lox/ast"
+	"lox/env"
+	"lox/loxerr"
+)
+
+// errorClassName is the global name of the built-in Error class.
+const errorClassName = "Error"
+
+// errorClass is the built-in Error class, as if declared by
+//
+//	class Error {
+//	  init(message) { this.message = message; }
+//	}
+//
+// Scripts throw and subclass it like any other class. Errors raised by the
+// runtime are caught as Error instances, which also carry the line and the
+// stack trace of the failure. The class holds no state, so every runtime
+// shares it.
+var errorClass = NewClass(errorClassName, map[string]Method{"init": errorInit{}}, nil)
+
+// ErrorClass returns the built-in Error class.
+func ErrorClass() *Class {
+	return errorClass
+}
+
+// errorInit is the initializer of the Error class.
+type errorInit struct{}
+
+func (errorInit) Arity() int {
+	return 1
+}
+
+func (errorInit) Bind(ins *Instance) Callable {
+	return &method{name: "init", arity: 1, fn: func(rt Runtime, args []any) (any, error) {
+		ins.fields["message"] = args[0]
+		return ins, nil
+	}}
+}
+
+// ErrStepLimit is the cause of the error raised when a run exceeds its
+// step budget.
+var ErrStepLimit = errors.New("execution limit exceeded")
+
+func (i *Interpreter) VisitThrowStmt(stmt *ast.ThrowStmt) any {
+	value := i.evaluate(stmt.Value)
+
+	rtErr := runtimeError(stmt.Keyword, "")
+	rtErr.Trace = i.stackTrace(rtErr.Position)
+	panic(Throw(rtErr, value))
+}
+
+// Throw makes err the error raised by throwing value. err holds the position
+// of the throw statement and the stack trace at that point.
+func Throw(err *loxerr.RuntimeError, value any) *loxerr.RuntimeError {
+	err.Msg = Stringify(value)
+	err.Thrown = true
+	err.Value = value
+	if ins, ok := value.(*Instance); ok && ins.class.IsSubclassOf(errorClass) {
+		if msg, ok := ins.fields["message"]; ok {
+			err.Msg = Stringify(msg)
+		}
+		describeError(ins, err)
+	}
+	return err
+}
+
+func (i *Interpreter) VisitTryStmt(stmt *ast.TryStmt) any {
+	c, caught := i.tryBlock(stmt.Body, env.New(i.env))
+
+	if caught != nil && stmt.CatchName != nil {
+		catchEnv := env.New(i.env)
+		catchEnv.Define(stmt.CatchName.Lexeme(), ErrorValue(caught))
+		c, caught = i.tryBlock(stmt.Catch, catchEnv)
+	}
+
+	if stmt.HasFinally {
+		// A finally clause that returns, breaks or throws replaces whatever
+		// the try and catch clauses did.
+		if fc := i.executeBlock(stmt.Finally, env.New(i.env)); fc != nil {
+			return fc
+		}
+	}
+
+	if caught != nil {
+		panic(caught)
+	}
+	return c
+}
+
+// tryBlock executes stmts in blockEnv, catching the runtime error they
+// raise, if any. The environment and call frames are restored to what they
+// were before the block.
+func (i *Interpreter) tryBlock(stmts []ast.Stmt, blockEnv *env.Env) (c *completion, caught *loxerr.RuntimeError) {
+	prevEnv, prevGlobals, prevCallSite := i.env, i.globals, i.callSite
+	depth := len(i.frames)
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		rtErr, ok := r.(*loxerr.RuntimeError)
+		if !ok || !Catchable(i.ctx, rtErr) {
+			panic(r)
+		}
+
+		if rtErr.Trace == nil {
+			rtErr.Trace = i.stackTrace(rtErr.Position)
+		}
+		i.env, i.globals, i.callSite = prevEnv, prevGlobals, prevCallSite
+		i.frames = i.frames[:depth]
+		caught = rtErr
+	}()
+
+	return i.executeBlock(stmts, blockEnv), nil
+}
+
+// Catchable reports whether a script may catch err, raised during a run
+// under ctx. Running out of steps or time ends the run regardless.
+func Catchable(ctx context.Context, err *loxerr.RuntimeError) bool {
+	if errors.Is(err.Cause, ErrStepLimit) {
+		return false
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err.Cause, ctxErr) {
+		return false
+	}
+	return true
+}
+
+// ErrorValue returns the value a catch clause binds for err: the thrown
+// value, or an Error instance describing an error raised by the runtime.
+func ErrorValue(err *loxerr.RuntimeError) any {
+	if err.Thrown {
+		return err.Value
+	}
+
+	ins := NewInstance(errorClass)
+	ins.fields["message"] = err.Msg
+	describeError(ins, err)
+	return ins
+}
+
+// describeError records the line and stack trace of err in the Error
+// instance ins.
+func describeError(ins *Instance, err *loxerr.RuntimeError) {
+	trace := make([]any, len(err.Trace))
+	for n, f := range err.Trace {
+		trace[n] = f.String()
+	}
+	ins.fields["line"] = float64(err.Line)
+	ins.fields["trace"] = NewList(trace)
+}
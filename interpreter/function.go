@@ -3,71 +3,86 @@ package interpreter
 import (
 	"lox/ast"
 	"lox/env"
+	"lox/token"
 )
 
-var _ Callable = (*Function)(nil)
+var (
+	_ Callable = (*Function)(nil)
+	_ Method   = (*Function)(nil)
+)
+
+// anonymousName names anonymous functions in String and stack traces.
+const anonymousName = "anonymous"
 
 type Function struct {
-	declaration   *ast.FunctionStmt
+	name          string
+	params        []*token.Token
+	body          []ast.Stmt
 	closure       *env.Env
+	globals       *env.Env // of the module declaring the function
 	isInitializer bool
 }
 
-func NewFunction(declaration *ast.FunctionStmt, closure *env.Env, isInitializer bool) *Function {
+func NewFunction(declaration *ast.FunctionStmt, closure *env.Env, globals *env.Env, isInitializer bool) *Function {
 	return &Function{
-		declaration:   declaration,
+		name:          declaration.Name.Lexeme(),
+		params:        declaration.Params,
+		body:          declaration.Body,
 		closure:       closure,
+		globals:       globals,
 		isInitializer: isInitializer,
 	}
 }
 
+// NewAnonymousFunction returns the function created by evaluating expr.
+func NewAnonymousFunction(expr *ast.FunctionExpr, closure *env.Env, globals *env.Env) *Function {
+	return &Function{
+		name:    anonymousName,
+		params:  expr.Params,
+		body:    expr.Body,
+		closure: closure,
+		globals: globals,
+	}
+}
+
 func (f *Function) Arity() int {
-	return len(f.declaration.Params)
+	return len(f.params)
 }
 
-func (f *Function) Call(interpreter *Interpreter, arguments []any) any {
+// Call runs f on the interpreter that created it, which is the only Runtime
+// able to execute its syntax tree.
+func (f *Function) Call(rt Runtime, arguments []any) any {
+	interpreter := rt.(*Interpreter)
 	env := env.New(f.closure)
-	for i := 0; i < len(f.declaration.Params); i++ {
-		env.Define(f.declaration.Params[i].Lexeme(), arguments[i])
+	for i := 0; i < len(f.params); i++ {
+		env.Define(f.params[i].Lexeme(), arguments[i])
 	}
 
-	var returnValue any
-
-	// hack for back to top of the Stack
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				tmp, ok := r.(*Return)
-				if !ok {
-					return
-				}
-
-				if f.isInitializer {
-					returnValue = f.closure.GetAt(0, "this")
-					return
-				}
-
-				// return statement
-				returnValue = tmp.Value
-			}
-		}()
-
-		interpreter.executeBlock(f.declaration.Body, env)
-	}()
+	// Global variables are looked up in the module that declared the
+	// function, wherever it is called from.
+	prevGlobals := interpreter.globals
+	interpreter.globals = f.globals
+	c := interpreter.executeBlock(f.body, env)
+	interpreter.globals = prevGlobals
 
 	if f.isInitializer {
-		return f.closure.GetAt(0, "this")
+		return f.closure.GetAt(0, 0) // this
 	}
-
-	return returnValue
+	if c != nil {
+		return c.value
+	}
+	return nil
 }
 
 func (f *Function) String() string {
-	return "<fn " + f.declaration.Name.Lexeme() + ">"
+	return "<fn " + f.name + ">"
 }
 
-func (f *Function) Bind(ins *Instance) *Function {
+func (f *Function) Bind(ins *Instance) Callable {
 	env := env.New(f.closure)
 	env.Define("this", ins)
-	return NewFunction(f.declaration, env, f.isInitializer)
+
+	bound := *f
+	bound.closure = env
+	return &bound
 }
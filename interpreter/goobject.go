@@ -0,0 +1,341 @@
+package interpreter
+
+import (
+	"fmt"
+	"lox/loxerr"
+	"lox/token"
+	"reflect"
+)
+
+var (
+	_ Object = (*Instance)(nil)
+	_ Object = (*GoObject)(nil)
+)
+
+// Object is a value with properties, read and written with the dot syntax.
+type Object interface {
+	Get(name *token.Token) any
+	Set(name *token.Token, value any)
+}
+
+// GoObject exposes a Go struct, map or slice to Lox through reflection.
+//
+// Exported struct fields can be read and written, and exported methods
+// called. A field holding a struct is read by reference when its parent can
+// be modified, so that assigning to its own fields changes the parent. Maps
+// with string keys expose their entries as properties. Slices and arrays
+// provide len(), get(i) and set(i, value).
+type GoObject struct {
+	v reflect.Value
+}
+
+func NewGoObject(v any) *GoObject {
+	return &GoObject{v: reflect.ValueOf(v)}
+}
+
+// Value returns the wrapped Go value.
+func (o *GoObject) Value() any {
+	return o.v.Interface()
+}
+
+func (o *GoObject) String() string {
+	return fmt.Sprint(o.v.Interface())
+}
+
+func (o *GoObject) Get(name *token.Token) any {
+	if method := o.v.MethodByName(name.Lexeme()); method.IsValid() {
+		return &goFunc{name: name.Lexeme(), fn: method}
+	}
+
+	v := reflect.Indirect(o.v)
+	switch v.Kind() {
+	case reflect.Struct:
+		if field, ok := exportedField(v, name.Lexeme()); ok {
+			if field.Kind() == reflect.Struct && field.CanAddr() {
+				return &GoObject{v: field.Addr()}
+			}
+			return FromGo(field.Interface())
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() == reflect.String {
+			key := reflect.ValueOf(name.Lexeme()).Convert(v.Type().Key())
+			if val := v.MapIndex(key); val.IsValid() {
+				return FromGo(val.Interface())
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if fn := o.sequenceMethod(v, name.Lexeme()); fn != nil {
+			return fn
+		}
+	}
+
+	panic(runtimeError(name, fmt.Sprintf("Undefined property '%s'.", name.Lexeme())))
+}
+
+func (o *GoObject) Set(name *token.Token, value any) {
+	v := reflect.Indirect(o.v)
+	switch v.Kind() {
+	case reflect.Struct:
+		field, ok := exportedField(v, name.Lexeme())
+		if !ok {
+			panic(runtimeError(name, fmt.Sprintf("Undefined property '%s'.", name.Lexeme())))
+		}
+		if !field.CanSet() {
+			panic(runtimeError(name, fmt.Sprintf("Cannot set property '%s'.", name.Lexeme())))
+		}
+		val, err := ToGo(value, field.Type())
+		if err != nil {
+			panic(runtimeError(name, err.Error()))
+		}
+		field.Set(val)
+		return
+	case reflect.Map:
+		if v.Type().Key().Kind() == reflect.String && !v.IsNil() {
+			val, err := ToGo(value, v.Type().Elem())
+			if err != nil {
+				panic(runtimeError(name, err.Error()))
+			}
+			v.SetMapIndex(reflect.ValueOf(name.Lexeme()).Convert(v.Type().Key()), val)
+			return
+		}
+	}
+
+	panic(runtimeError(name, "Only instances have fields."))
+}
+
+func (o *GoObject) sequenceMethod(v reflect.Value, name string) Callable {
+	index := func(args []any) (int, error) {
+		i, err := ArgInt(args, 0)
+		if err != nil {
+			return 0, err
+		}
+		if i < 0 || i >= v.Len() {
+			return 0, fmt.Errorf("Index %d out of range [0, %d).", i, v.Len())
+		}
+		return i, nil
+	}
+
+	switch name {
+	case "len":
+		return NewNative(name, 0, func(args []any) (any, error) {
+			return v.Len(), nil
+		})
+	case "get":
+		return NewNative(name, 1, func(args []any) (any, error) {
+			i, err := index(args)
+			if err != nil {
+				return nil, err
+			}
+			return v.Index(i).Interface(), nil
+		})
+	case "set":
+		return NewNative(name, 2, func(args []any) (any, error) {
+			i, err := index(args)
+			if err != nil {
+				return nil, err
+			}
+			elem := v.Index(i)
+			if !elem.CanSet() {
+				return nil, fmt.Errorf("Cannot set elements of %s.", v.Type())
+			}
+			val, err := ToGo(args[1], elem.Type())
+			if err != nil {
+				return nil, err
+			}
+			elem.Set(val)
+			return args[1], nil
+		})
+	}
+
+	return nil
+}
+
+func (o *GoObject) equal(other *GoObject) bool {
+	if o.v.Type() != other.v.Type() {
+		return false
+	}
+	switch o.v.Kind() {
+	case reflect.Func:
+		return o.v.Pointer() == other.v.Pointer()
+	case reflect.Map, reflect.Slice:
+		return o.v.Pointer() == other.v.Pointer() && o.v.Len() == other.v.Len()
+	}
+	if !o.v.Comparable() {
+		return false
+	}
+	return o.v.Equal(other.v)
+}
+
+func exportedField(v reflect.Value, name string) (reflect.Value, bool) {
+	field, ok := v.Type().FieldByName(name)
+	if !ok || !field.IsExported() {
+		return reflect.Value{}, false
+	}
+	return v.FieldByIndex(field.Index), true
+}
+
+// goFunc is a Go function or bound method called through reflection.
+type goFunc struct {
+	name string
+	fn   reflect.Value
+}
+
+func (f *goFunc) Arity() int {
+	if f.fn.Type().IsVariadic() {
+		return Variadic
+	}
+	return f.fn.Type().NumIn()
+}
+
+func (f *goFunc) Call(rt Runtime, arguments []any) any {
+	val, err := f.CallBuiltin(rt, arguments)
+	if err != nil {
+		panic(&loxerr.RuntimeError{Msg: err.Error(), Cause: err})
+	}
+	return val
+}
+
+// CallBuiltin converts the arguments to the parameter types, calls the
+// function and converts its first result back to Lox. A non-nil trailing
+// error result is returned as the error.
+func (f *goFunc) CallBuiltin(rt Runtime, arguments []any) (any, error) {
+	t := f.fn.Type()
+	if t.IsVariadic() && len(arguments) < t.NumIn()-1 {
+		return nil, fmt.Errorf("Expected at least %d arguments but got %d.", t.NumIn()-1, len(arguments))
+	}
+
+	in := make([]reflect.Value, len(arguments))
+	for i, arg := range arguments {
+		var pt reflect.Type
+		if t.IsVariadic() && i >= t.NumIn()-1 {
+			pt = t.In(t.NumIn() - 1).Elem()
+		} else {
+			pt = t.In(i)
+		}
+
+		val, err := ToGo(arg, pt)
+		if err != nil {
+			return nil, fmt.Errorf("Argument %d: %s", i+1, err)
+		}
+		in[i] = val
+	}
+
+	out := f.fn.Call(in)
+	if n := len(out); n > 0 && t.Out(n-1) == errorType {
+		if err, _ := out[n-1].Interface().(error); err != nil {
+			return nil, err
+		}
+		out = out[:n-1]
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return FromGo(out[0].Interface()), nil
+}
+
+func (f *goFunc) String() string {
+	return "<native fn>"
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// FromGo converts a Go value to Lox. Booleans and strings are kept, Go
+// numbers become float64, nil pointers, maps, slices and functions become
+// nil, functions become callables, and other structs, maps, slices and
+// pointers are wrapped in a GoObject. Lox values are returned unchanged.
+func FromGo(v any) any {
+	switch v.(type) {
+	case nil, bool, float64, string, Callable, *Instance, *GoObject, *List, *Map, *Module:
+		return v
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.String:
+		return rv.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+	case reflect.Func:
+		if rv.IsNil() {
+			return nil
+		}
+		return &goFunc{name: rv.Type().String(), fn: rv}
+	}
+
+	return &GoObject{v: rv}
+}
+
+// ToGo converts a Lox value to the Go type t. Numbers convert to any numeric
+// type they fit, integer types requiring whole numbers; a GoObject converts
+// to the type of the value it wraps.
+func ToGo(v any, t reflect.Type) (reflect.Value, error) {
+	if obj, ok := v.(*GoObject); ok {
+		if obj.v.Type().AssignableTo(t) {
+			return obj.v, nil
+		}
+		// A struct field read by reference converts back to the struct.
+		if obj.v.Kind() == reflect.Pointer && obj.v.Type().Elem().AssignableTo(t) {
+			return obj.v.Elem(), nil
+		}
+		return reflect.Value{}, conversionError(v, t)
+	}
+
+	if v == nil {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, conversionError(v, t)
+	}
+
+	if t.Kind() == reflect.Interface {
+		if reflect.TypeOf(v).AssignableTo(t) {
+			return reflect.ValueOf(v), nil
+		}
+		return reflect.Value{}, conversionError(v, t)
+	}
+
+	switch v := v.(type) {
+	case bool:
+		if t.Kind() == reflect.Bool {
+			return reflect.ValueOf(v).Convert(t), nil
+		}
+	case string:
+		if t.Kind() == reflect.String {
+			return reflect.ValueOf(v).Convert(t), nil
+		}
+	case float64:
+		switch t.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return reflect.ValueOf(v).Convert(t), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n := reflect.New(t).Elem()
+			if v == float64(int64(v)) && !n.OverflowInt(int64(v)) {
+				n.SetInt(int64(v))
+				return n, nil
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			n := reflect.New(t).Elem()
+			if v >= 0 && v == float64(uint64(v)) && !n.OverflowUint(uint64(v)) {
+				n.SetUint(uint64(v))
+				return n, nil
+			}
+		}
+	}
+
+	return reflect.Value{}, conversionError(v, t)
+}
+
+func conversionError(v any, t reflect.Type) error {
+	return fmt.Errorf("Cannot convert %s to %s.", Stringify(v), t)
+}
@@ -5,8 +5,6 @@ import (
 	"lox/token"
 )
 
-var _ Callable = (*Instance)(nil)
-
 type Instance struct {
 	class  *Class
 	fields map[string]any
@@ -19,12 +17,20 @@ func NewInstance(c *Class) *Instance {
 	}
 }
 
-func (i *Instance) Arity() int {
-	return 0
+// Class returns the class ins was created from.
+func (i *Instance) Class() *Class {
+	return i.class
+}
+
+// Field returns the value of the field name, without looking at methods.
+func (i *Instance) Field(name string) (any, bool) {
+	val, ok := i.fields[name]
+	return val, ok
 }
 
-func (i *Instance) Call(interpreter *Interpreter, arguments []any) any {
-	panic("unimplemented")
+// SetField sets the field name to value.
+func (i *Instance) SetField(name string, value any) {
+	i.fields[name] = value
 }
 
 func (i *Instance) String() string {
@@ -46,5 +52,5 @@ func (i *Instance) Get(name *token.Token) any {
 		return method.Bind(i)
 	}
 
-	panic(fmt.Errorf("%s Undefined property '%s'.", name, name.Lexeme()))
+	panic(runtimeError(name, fmt.Sprintf("Undefined property '%s'.", name.Lexeme())))
 }
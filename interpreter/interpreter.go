@@ -1,11 +1,12 @@
 package interpreter
 
 import (
+	"context"
 	"fmt"
 	"lox/ast"
 	"lox/env"
+	"lox/loxerr"
 	"lox/token"
-	"reflect"
 )
 
 var (
@@ -13,48 +14,139 @@ var (
 	_ ast.StmtVisitor = (*Interpreter)(nil)
 )
 
-var (
-	GLOBAL_ENV = env.New(nil)
-)
+type Interpreter struct {
+	*Host
+
+	globals  *env.Env // globals of the module running now
+	env      *env.Env
+	locals   map[ast.Expr]slot
+	frames   []frame
+	callSite *token.Token // of the built-in running now, for its callbacks
 
-func init() {
-	GLOBAL_ENV.Define("clock", NewClock())
+	limits Limits
+	ctx    context.Context
+	steps  int
+
+	importer Importer
 }
 
-type Interpreter struct {
-	env    *env.Env
-	locals map[ast.Expr]int
+// frame is an active call of a Lox function or class.
+type frame struct {
+	function string
+	callSite *token.Token // closing paren of the call expression, if any
+}
+
+// slot is where a local variable reference was resolved: the number of
+// scopes out from the reference, and the variable's slot in that scope.
+type slot struct {
+	depth int
+	index int
 }
 
+// scriptFrame names the top-level code in stack traces.
+const scriptFrame = "script"
+
+// New returns an interpreter with a new Host.
 func New() *Interpreter {
+	h := NewHost()
 	return &Interpreter{
-		env:    GLOBAL_ENV,
-		locals: make(map[ast.Expr]int),
+		Host:    h,
+		globals: h.main,
+		env:     h.main,
+		locals:  make(map[ast.Expr]slot),
+		limits:  Limits{MaxCallDepth: DefaultMaxCallDepth},
+		ctx:     context.Background(),
 	}
 }
 
-func (i *Interpreter) Interpret(stmts []ast.Stmt) {
+// Interpret runs stmts and reports the first runtime error, if any.
+func (i *Interpreter) Interpret(stmts []ast.Stmt) (err error) {
+	defer i.recoverError(&err)
+
 	for _, stmt := range stmts {
 		i.execute(stmt)
 	}
+	return nil
+}
+
+// Evaluate evaluates a single expression, such as the last line typed in a
+// REPL.
+func (i *Interpreter) Evaluate(expr ast.Expr) (val any, err error) {
+	defer i.recoverError(&err)
+
+	return i.evaluate(expr), nil
+}
+
+// Call calls callee from Go code, checking the number of arguments as a
+// call expression would.
+func (i *Interpreter) Call(callee Callable, args []any) (val any, err error) {
+	defer i.recoverError(&err)
+
+	if arity := callee.Arity(); arity != Variadic && len(args) != arity {
+		return nil, &loxerr.RuntimeError{
+			Msg: fmt.Sprintf("Expected %d arguments but got %d.", arity, len(args)),
+		}
+	}
+	// The call counts toward MaxCallDepth like one made by a script.
+	if name, ok := frameName(callee); ok {
+		return i.callFrame(callee, name, args, nil), nil
+	}
+	return callee.Call(i, args), nil
+}
+
+// Callback calls callee on behalf of a built-in method, such as the
+// function passed to map. The call shows up in stack traces as made from
+// the call of the built-in.
+func (i *Interpreter) Callback(callee Callable, args ...any) (any, error) {
+	if arity := callee.Arity(); arity != Variadic && len(args) != arity {
+		return nil, fmt.Errorf("Expected %d arguments but got %d.", arity, len(args))
+	}
+	if name, ok := frameName(callee); ok {
+		return i.callFrame(callee, name, args, i.callSite), nil
+	}
+	return callee.Call(i, args), nil
+}
+
+// recoverError stops a runtime error unwinding out of the interpreter and
+// stores it in err, along with the Lox stack trace. Any other panic, such as
+// a bad type assertion, is a bug in the interpreter and keeps unwinding.
+func (i *Interpreter) recoverError(err *error) {
+	if r := recover(); r != nil {
+		rtErr, ok := r.(*loxerr.RuntimeError)
+		if !ok {
+			panic(r)
+		}
+		if rtErr.Trace == nil {
+			rtErr.Trace = i.stackTrace(rtErr.Position)
+		}
+		i.frames = i.frames[:0]
+		i.callSite = nil
+		i.env = i.main
+		i.globals = i.main
+		*err = rtErr
+	}
 }
 
 func (i *Interpreter) evaluate(expr ast.Expr) any {
 	return expr.Accept(i)
 }
 
-func (i *Interpreter) execute(stmt ast.Stmt) {
-	stmt.Accept(i)
+// execute runs stmt and reports how it completed: nil when it ran to its
+// end, or the return, break or continue that jumped out of it.
+func (i *Interpreter) execute(stmt ast.Stmt) *completion {
+	i.step(stmt)
+	c, _ := stmt.Accept(i).(*completion)
+	return c
 }
 
-func (i *Interpreter) Resolve(expr ast.Expr, depth int) {
-	i.locals[expr] = depth
+func (i *Interpreter) Resolve(expr ast.Expr, depth int, index int) {
+	i.locals[expr] = slot{depth, index}
 }
 
 // Stmt visitors
 func (i *Interpreter) VisitPrintStmt(stmt *ast.PrintStmt) any {
 	val := i.evaluate(stmt.Expression)
-	fmt.Println(val)
+	fmt.Fprintln(i.stdout, Stringify(val))
 	return nil
 }
 
@@ -64,39 +156,156 @@ func (i *Interpreter) VisitExpressionStmt(stmt *ast.ExpressionStmt) any {
 }
 
 func (i *Interpreter) VisitVarStmt(stmt *ast.VarStmt) any {
-	var v any
-	if stmt.Initializer != nil {
-		v = i.evaluate(stmt.Initializer)
+	if stmt.Initializer == nil {
+		i.env.Define(stmt.Name.Lexeme(), nil)
+		return nil
 	}
 
-	i.env.Define(stmt.Name.Lexeme(), v)
+	// A local exists before its initializer runs, for the functions in the
+	// initializer that refer to it.
+	if slot, ok := i.env.Declare(); ok {
+		i.env.AssignAt(0, slot, i.evaluate(stmt.Initializer))
+		return nil
+	}
+	i.env.Define(stmt.Name.Lexeme(), i.evaluate(stmt.Initializer))
 	return nil
 }
 
 func (i *Interpreter) VisitBlockStmt(stmt *ast.BlockStmt) any {
 	newEnv := env.New(i.env)
-	i.executeBlock(stmt.Statements, newEnv)
-	return nil
+	return i.executeBlock(stmt.Statements, newEnv)
 }
 
 func (i *Interpreter) VisitIfStmt(stmt *ast.IfStmt) any {
-	if i.isTruthy(i.evaluate(stmt.Condition)) {
-		i.execute(stmt.Then)
+	if isTruthy(i.evaluate(stmt.Condition)) {
+		return i.execute(stmt.Then)
 	} else if stmt.Else != nil {
-		i.execute(stmt.Else)
+		return i.execute(stmt.Else)
 	}
 	return nil
 }
 
 func (i *Interpreter) VisitWhileStmt(stmt *ast.WhileStmt) any {
-	for i.isTruthy(i.evaluate(stmt.Condition)) {
-		i.execute(stmt.Body)
+	for isTruthy(i.evaluate(stmt.Condition)) {
+		if c := i.execute(stmt.Body); c != nil {
+			if c.kind == breakCompletion {
+				break
+			}
+			if c.kind != continueCompletion {
+				return c
+			}
+		}
+		if stmt.Increment != nil {
+			i.evaluate(stmt.Increment)
+		}
 	}
 	return nil
 }
 
+func (i *Interpreter) VisitForInStmt(stmt *ast.ForInStmt) any {
+	var result *completion
+	// each runs the body with the loop variable bound to value, reporting
+	// whether the loop goes on.
+	each := func(value any) bool {
+		prevEnv := i.env
+		i.env = env.New(prevEnv)
+		i.env.Define(stmt.Name.Lexeme(), value)
+		c := i.execute(stmt.Body)
+		i.env = prevEnv
+
+		switch {
+		case c == nil || c.kind == continueCompletion:
+			return true
+		case c.kind == breakCompletion:
+			return false
+		}
+		result = c
+		return false
+	}
+
+	switch iterable := i.evaluate(stmt.Iterable).(type) {
+	case *List:
+		// The length is read on every iteration, so elements pushed by the
+		// body are visited too.
+		for n := 0; n < len(iterable.elements); n++ {
+			if !each(iterable.elements[n]) {
+				break
+			}
+		}
+	case *Map:
+		// Iterate over a copy of the keys, so the body may add or delete
+		// entries.
+		keys := append([]any(nil), iterable.Keys()...)
+		for _, key := range keys {
+			if !each(key) {
+				break
+			}
+		}
+	case string:
+		for _, r := range iterable {
+			if !each(string(r)) {
+				break
+			}
+		}
+	case *Instance:
+		i.iterateInstance(stmt, iterable, each)
+	default:
+		panic(runtimeError(stmt.Keyword, "Can only iterate over lists, maps, strings and iterable instances."))
+	}
+
+	return result
+}
+
+// iterateInstance runs the iterator protocol: ins.iter() returns an
+// iterator, and the loop calls its next() until done() is true.
+func (i *Interpreter) iterateInstance(stmt *ast.ForInStmt, ins *Instance, each func(any) bool) {
+	if ins.class.FindMethod("iter") == nil {
+		panic(runtimeError(stmt.Keyword, "Can only iterate over lists, maps, strings and iterable instances."))
+	}
+
+	span := stmt.Iterable.Span()
+	iterator := i.invokeMethod(ins, "iter", span)
+	obj, ok := iterator.(Object)
+	if !ok {
+		panic(runtimeError(stmt.Keyword, "iter() must return an object with next() and done() methods."))
+	}
+
+	for !isTruthy(i.invokeMethod(obj, "done", span)) {
+		if !each(i.invokeMethod(obj, "next", span)) {
+			return
+		}
+	}
+}
+
+// invokeMethod calls the method name of obj without arguments, reporting
+// errors at span.
+func (i *Interpreter) invokeMethod(obj Object, name string, span token.Span) any {
+	tok := token.New(token.IDENTIFIER, name, nil, span)
+	callee, ok := obj.Get(tok).(Callable)
+	if !ok {
+		panic(runtimeError(tok, fmt.Sprintf("'%s' must be a method.", name)))
+	}
+
+	prevCallSite := i.callSite
+	i.callSite = tok
+	val, err := i.Callback(callee)
+	i.callSite = prevCallSite
+	if err != nil {
+		panic(runtimeError(tok, err.Error()))
+	}
+	return val
+}
+
+func (i *Interpreter) VisitBreakStmt(stmt *ast.BreakStmt) any {
+	return breakSignal
+}
+
+func (i *Interpreter) VisitContinueStmt(stmt *ast.ContinueStmt) any {
+	return continueSignal
+}
+
 func (i *Interpreter) VisitFunctionStmt(stmt *ast.FunctionStmt) any {
-	fun := NewFunction(stmt, i.env, false)
+	fun := NewFunction(stmt, i.env, i.globals, false)
 	i.env.Define(stmt.Name.Lexeme(), fun)
 	return nil
 }
@@ -107,11 +316,7 @@ func (i *Interpreter) VisitReturnStmt(stmt *ast.ReturnStmt) any {
 		value = i.evaluate(stmt.Value)
 	}
 
-	r := &Return{
-		Value: value,
-	}
-	// hack to back top of the Stack
-	panic(r)
+	return &completion{kind: returnCompletion, value: value}
 }
 
 func (i *Interpreter) VisitClassStmt(stmt *ast.ClassStmt) any {
@@ -120,21 +325,19 @@ func (i *Interpreter) VisitClassStmt(stmt *ast.ClassStmt) any {
 		tmpClass := i.evaluate(stmt.SuperClass)
 		superClass, _ = tmpClass.(*Class)
 		if superClass == nil {
-			panic(fmt.Errorf("Superclass '%s' must be a class.", stmt.SuperClass.Name))
+			panic(runtimeError(stmt.SuperClass.Name, "Superclass must be a class."))
 		}
 	}
 
-	i.env.Define(stmt.Name.Lexeme(), nil)
-
 	if stmt.SuperClass != nil {
 		i.env = env.New(i.env)
 		i.env.Define("super", superClass)
 	}
 
-	methods := map[string]*Function{}
+	methods := map[string]Method{}
 	for _, method := range stmt.Methods {
 		isInitializer := method.Name.Lexeme() == "init"
-		f := NewFunction(method, i.env, isInitializer)
+		f := NewFunction(method, i.env, i.globals, isInitializer)
 		methods[method.Name.Lexeme()] = f
 	}
 
@@ -144,7 +347,9 @@ func (i *Interpreter) VisitClassStmt(stmt *ast.ClassStmt) any {
 		i.env = i.env.Enclosing()
 	}
 
-	i.env.Assign(stmt.Name, c)
+	// Methods see the class through their closure only once they run, so it
+	// is enough to define its name now.
+	i.env.Define(stmt.Name.Lexeme(), c)
 
 	return nil
 }
@@ -163,9 +368,9 @@ func (i *Interpreter) VisitUnaryExpr(expr *ast.UnaryExpr) any {
 
 	switch expr.Op.Type() {
 	case token.BANG:
-		return !i.isTruthy(right)
+		return !isTruthy(right)
 	case token.MINUS:
-		v := right.(float64)
+		v := checkNumberOperand(&expr.Op, right)
 		return -v
 	}
 
@@ -176,40 +381,49 @@ func (i *Interpreter) VisitBinaryExpr(expr *ast.BinaryExpr) any {
 	left := i.evaluate(expr.Left)
 	right := i.evaluate(expr.Right)
 
-	// TODO: check before cast value
 	switch expr.Op.Type() {
 	case token.MINUS:
-		return left.(float64) - right.(float64)
+		l, r := checkNumberOperands(&expr.Op, left, right)
+		return l - r
 	case token.SLASH:
-		return left.(float64) / right.(float64)
+		l, r := checkNumberOperands(&expr.Op, left, right)
+		return l / r
 	case token.STAR:
-		return left.(float64) * right.(float64)
+		l, r := checkNumberOperands(&expr.Op, left, right)
+		return l * r
 	case token.PLUS:
-		if reflect.TypeOf(left).Kind() == reflect.Float64 &&
-			reflect.TypeOf(right).Kind() == reflect.Float64 {
-			return left.(float64) + right.(float64)
-		}
-
-		if reflect.TypeOf(left).Kind() == reflect.String &&
-			reflect.TypeOf(right).Kind() == reflect.String {
-			return left.(string) + right.(string)
+		switch l := left.(type) {
+		case float64:
+			if r, ok := right.(float64); ok {
+				return l + r
+			}
+		case string:
+			if r, ok := right.(string); ok {
+				return l + r
+			}
 		}
+		panic(runtimeError(&expr.Op, "Operands must be two numbers or two strings."))
 	case token.GREATER:
-		return left.(float64) > right.(float64)
+		l, r := checkNumberOperands(&expr.Op, left, right)
+		return l > r
 	case token.GREATER_EQUAL:
-		return left.(float64) >= right.(float64)
+		l, r := checkNumberOperands(&expr.Op, left, right)
+		return l >= r
 	case token.LESS:
-		return left.(float64) < right.(float64)
+		l, r := checkNumberOperands(&expr.Op, left, right)
+		return l < r
 	case token.LESS_EQUAL:
-		return left.(float64) <= right.(float64)
+		l, r := checkNumberOperands(&expr.Op, left, right)
+		return l <= r
 	case token.BANG_EQUAL:
-		return !i.isEqual(left, right)
+		return !Equal(left, right)
 	case token.EQUAL_EQUAL:
-		return i.isEqual(left, right)
+		return Equal(left, right)
 	}
 
 	return nil
 }
+
 func (i *Interpreter) VisitVariableExpr(expr *ast.VariableExpr) any {
 	return i.lookUpVariable(expr.Name, expr)
 }
@@ -217,11 +431,11 @@ func (i *Interpreter) VisitVariableExpr(expr *ast.VariableExpr) any {
 func (i *Interpreter) VisitAssignExpr(expr *ast.AssignExpr) any {
 	val := i.evaluate(expr.Value)
 
-	distance, has := i.locals[expr]
+	s, has := i.locals[expr]
 	if has {
-		i.env.AssignAt(distance, expr.Name, val)
-	} else {
-		GLOBAL_ENV.Assign(expr.Name, val)
+		i.env.AssignAt(s.depth, s.index, val)
+	} else if err := i.globals.Assign(expr.Name, val); err != nil {
+		panic(runtimeError(expr.Name, err.Error()))
 	}
 
 	return val
@@ -230,11 +444,11 @@ func (i *Interpreter) VisitAssignExpr(expr *ast.AssignExpr) any {
 func (i *Interpreter) VisitLogicalExpr(expr *ast.LogicalExpr) any {
 	left := i.evaluate(expr.Left)
 	if expr.Operator.Type() == token.OR {
-		if i.isTruthy(left) {
+		if isTruthy(left) {
 			return left
 		}
 	} else {
-		if !i.isTruthy(left) {
+		if !isTruthy(left) {
 			return left
 		}
 	}
@@ -252,20 +466,65 @@ func (i *Interpreter) VisitCallExpr(expr *ast.CallExpr) any {
 
 	function, ok := callee.(Callable)
 	if !ok {
-		panic("Can't parse callee to LoxCallable")
+		panic(runtimeError(expr.Paren, "Can only call functions and classes."))
 	}
-	if len(args) != function.Arity() {
-		panic(fmt.Errorf("Expect %d arguments but got %d.", function.Arity(), len(args)))
+	if arity := function.Arity(); arity != Variadic && len(args) != arity {
+		panic(runtimeError(expr.Paren, fmt.Sprintf("Expected %d arguments but got %d.", arity, len(args))))
 	}
 
+	if name, ok := frameName(function); ok {
+		return i.callFrame(function, name, args, expr.Paren)
+	}
+	if f, ok := function.(Builtin); ok {
+		// Native functions do not show up in stack traces; their errors are
+		// reported at the call.
+		prevCallSite := i.callSite
+		i.callSite = expr.Paren
+		val, err := f.CallBuiltin(i, args)
+		i.callSite = prevCallSite
+		if err != nil {
+			rtErr := runtimeError(expr.Paren, err.Error())
+			rtErr.Cause = err
+			panic(rtErr)
+		}
+		return val
+	}
 	return function.Call(i, args)
 }
 
+// frameName returns the name of function in stack traces, if it has a
+// frame there: only Lox functions and classes do.
+func frameName(function Callable) (string, bool) {
+	switch f := function.(type) {
+	case *Function:
+		return f.name, true
+	case *Class:
+		return f.name, true
+	}
+	return "", false
+}
+
+// callFrame calls function in a new frame named name, which returns to
+// callSite.
+func (i *Interpreter) callFrame(function Callable, name string, args []any, callSite *token.Token) any {
+	// The frame is only popped on a normal return: when a runtime error
+	// unwinds the Go stack, Interpret reads the frames left behind to build
+	// the trace.
+	if max := i.limits.MaxCallDepth; max > 0 && len(i.frames) >= max {
+		panic(runtimeError(callSite, "Stack overflow."))
+	}
+	i.frames = append(i.frames, frame{function: name, callSite: callSite})
+	result := function.Call(i, args)
+	i.frames = i.frames[:len(i.frames)-1]
+
+	return result
+}
+
 func (i *Interpreter) VisitGetExpr(expr *ast.GetExpr) any {
 	obj := i.evaluate(expr.Object)
-	ins, ok := obj.(*Instance)
+	ins, ok := obj.(Object)
 	if !ok {
-		panic(fmt.Errorf("%s Only instances have properties.", expr.Name))
+		panic(runtimeError(expr.Name, "Only instances have properties."))
 	}
 
 	return ins.Get(expr.Name)
@@ -273,9 +532,9 @@ func (i *Interpreter) VisitGetExpr(expr *ast.GetExpr) any {
 
 func (i *Interpreter) VisitSetExpr(expr *ast.SetExpr) any {
 	obj := i.evaluate(expr.Object)
-	ins, ok := obj.(*Instance)
+	ins, ok := obj.(Object)
 	if !ok {
-		panic(fmt.Errorf("%s Only instances have fields.", expr.Name))
+		panic(runtimeError(expr.Name, "Only instances have fields."))
 	}
 
 	val := i.evaluate(expr.Value)
@@ -284,74 +543,163 @@ func (i *Interpreter) VisitSetExpr(expr *ast.SetExpr) any {
 	return val
 }
 
-func (i *Interpreter) VisitThisExpr(expr *ast.ThisExpr) any {
-	return i.lookUpVariable(expr.Keyword, expr)
+func (i *Interpreter) VisitFunctionExpr(expr *ast.FunctionExpr) any {
+	return NewAnonymousFunction(expr, i.env, i.globals)
 }
 
-func (i *Interpreter) VisitSuperExpr(expr *ast.SuperExpr) any {
-	distance := i.locals[expr]
-	superClass, ok := i.env.GetAt(distance, "super").(*Class)
+func (i *Interpreter) VisitListExpr(expr *ast.ListExpr) any {
+	elements := make([]any, len(expr.Elements))
+	for n, element := range expr.Elements {
+		elements[n] = i.evaluate(element)
+	}
+	return NewList(elements)
+}
+
+func (i *Interpreter) VisitMapExpr(expr *ast.MapExpr) any {
+	m := NewMap()
+	for n, key := range expr.Keys {
+		k := i.evaluate(key)
+		v := i.evaluate(expr.Values[n])
+		if err := m.Put(k, v); err != nil {
+			panic(runtimeError(expr.Brace, err.Error()))
+		}
+	}
+	return m
+}
+
+func (i *Interpreter) VisitIndexExpr(expr *ast.IndexExpr) any {
+	obj := i.evaluate(expr.Object)
+	index := i.evaluate(expr.Index)
+	indexable, ok := obj.(Indexable)
 	if !ok {
-		panic(fmt.Errorf("Get supper class error at distance %d", distance))
+		panic(runtimeError(expr.Bracket, "Only lists and maps can be indexed."))
 	}
-	object, ok := i.env.GetAt(distance-1, "this").(*Instance)
+
+	return indexable.Index(expr.Bracket, index)
+}
+
+func (i *Interpreter) VisitIndexSetExpr(expr *ast.IndexSetExpr) any {
+	obj := i.evaluate(expr.Object)
+	index := i.evaluate(expr.Index)
+	indexable, ok := obj.(Indexable)
 	if !ok {
-		panic(fmt.Errorf("Get instance got error at distance %d", distance-1))
+		panic(runtimeError(expr.Bracket, "Only lists and maps can be indexed."))
 	}
 
+	val := i.evaluate(expr.Value)
+	indexable.SetIndex(expr.Bracket, index, val)
+
+	return val
+}
+
+func (i *Interpreter) VisitThisExpr(expr *ast.ThisExpr) any {
+	return i.lookUpVariable(expr.Keyword, expr)
+}
+
+func (i *Interpreter) VisitSuperExpr(expr *ast.SuperExpr) any {
+	// super and this are alone in their scopes.
+	distance := i.locals[expr].depth
+	superClass := i.env.GetAt(distance, 0).(*Class)
+	object := i.env.GetAt(distance-1, 0).(*Instance)
+
 	method := superClass.FindMethod(expr.Method.Lexeme())
 	if method == nil {
-		panic(fmt.Errorf("Undefined property '%s' of method '%s'.", expr.Method.Lexeme(), expr.Method.String()))
+		panic(runtimeError(expr.Method, fmt.Sprintf("Undefined property '%s'.", expr.Method.Lexeme())))
 	}
 
 	return method.Bind(object)
 }
 
-func (i *Interpreter) executeBlock(stmts []ast.Stmt, env *env.Env) {
+// executeBlock runs stmts in env, stopping at the first one that does not
+// complete normally. A runtime error leaves env in place; the code recovering
+// from it restores the environment.
+func (i *Interpreter) executeBlock(stmts []ast.Stmt, env *env.Env) *completion {
 	prevEnv := i.env
-	defer func() {
-		i.env = prevEnv
-	}()
 	i.env = env
 
 	for _, stmt := range stmts {
-		i.execute(stmt)
+		if c := i.execute(stmt); c != nil {
+			i.env = prevEnv
+			return c
+		}
 	}
+
+	i.env = prevEnv
+	return nil
 }
 
 func (i *Interpreter) lookUpVariable(name *token.Token, expr ast.Expr) any {
-	distance, has := i.locals[expr]
+	s, has := i.locals[expr]
 	if has {
-		return i.env.GetAt(distance, name.Lexeme())
+		return i.env.GetAt(s.depth, s.index)
 	}
 
-	val, err := GLOBAL_ENV.Get(name)
+	val, err := i.globals.Get(name)
 	if err != nil {
-		panic(fmt.Errorf("GlobalEnv.Get error: %w", err))
+		panic(runtimeError(name, err.Error()))
 	}
 
 	return val
 }
 
-func (i *Interpreter) isEqual(left any, right any) bool {
-	if left == nil && right == nil {
-		return true
+// Equal compares numbers, strings and booleans by value and everything
+// else (instances, classes, functions) by identity. Every Lox value is a
+// comparable Go value, so == does exactly that; NaN is not equal to itself.
+// Go objects are equal when they wrap equal Go values.
+func Equal(left any, right any) bool {
+	if l, ok := left.(*GoObject); ok {
+		r, ok := right.(*GoObject)
+		return ok && l.equal(r)
 	}
-	if left == nil {
+	return left == right
+}
+
+// isTruthy follows Ruby's rule: nil and false are falsey, everything else
+// is truthy.
+func isTruthy(obj any) bool {
+	if obj == nil {
 		return false
 	}
 
-	return reflect.DeepEqual(left, right)
+	if b, ok := obj.(bool); ok {
+		return b
+	}
+
+	return true
 }
 
-func (i *Interpreter) isTruthy(obj any) bool {
-	if obj == nil {
-		return false
+// stackTrace describes the active calls, innermost first. pos is where the
+// innermost frame was when the error was raised.
+func (i *Interpreter) stackTrace(pos token.Position) []loxerr.Frame {
+	trace := make([]loxerr.Frame, 0, len(i.frames)+1)
+	for idx := len(i.frames) - 1; idx >= 0; idx-- {
+		f := i.frames[idx]
+		trace = append(trace, loxerr.Frame{Function: f.function, Position: pos})
+		pos = token.Position{}
+		if f.callSite != nil { // nil for a call made from Go
+			pos = f.callSite.Pos()
+		}
+	}
+
+	return append(trace, loxerr.Frame{Function: scriptFrame, Position: pos})
+}
+
+func checkNumberOperand(op *token.Token, operand any) float64 {
+	if v, ok := operand.(float64); ok {
+		return v
 	}
+	panic(runtimeError(op, "Operand must be a number."))
+}
 
-	if reflect.TypeOf(obj).Kind() == reflect.Bool {
-		return reflect.ValueOf(obj).Bool()
+func checkNumberOperands(op *token.Token, left any, right any) (float64, float64) {
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if lok && rok {
+		return l, r
 	}
+	panic(runtimeError(op, "Operands must be numbers."))
+}
 
-	return false
+func runtimeError(tok *token.Token, msg string) *loxerr.RuntimeError {
+	return loxerr.NewRuntimeError(tok, msg)
 }
@@ -0,0 +1,73 @@
+package interpreter
+
+import (
+	"context"
+	"lox/ast"
+	"lox/loxerr"
+)
+
+// DefaultMaxCallDepth bounds nested Lox calls unless Limits says otherwise,
+// so runaway recursion fails with "Stack overflow." before it exhausts the
+// Go stack.
+const DefaultMaxCallDepth = 10000
+
+// contextCheckInterval is how many statements run between two checks of
+// the run's context.
+const contextCheckInterval = 1024
+
+// Limits bounds the work a single run may do. A zero field means no limit.
+type Limits struct {
+	// MaxSteps is the number of statements a run may execute.
+	MaxSteps int
+
+	// MaxCallDepth is the number of nested Lox function and class calls.
+	MaxCallDepth int
+}
+
+// SetLimits replaces the limits of later runs.
+func (i *Interpreter) SetLimits(limits Limits) {
+	i.limits = limits
+}
+
+// Start begins a run: it resets the step count, and makes the interpreter
+// stop with a runtime error once ctx is done. When ctx is done already, it
+// returns that error and the run must not go on.
+func (i *Interpreter) Start(ctx context.Context) error {
+	i.ctx = ctx
+	i.steps = 0
+	return Interrupted(ctx)
+}
+
+// Interrupted returns the runtime error ending a run under ctx, or nil
+// while ctx is not done.
+func Interrupted(ctx context.Context) error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+	return &loxerr.RuntimeError{Msg: "Execution interrupted: " + err.Error() + ".", Cause: err}
+}
+
+// step accounts for executing stmt, raising a runtime error when the run is
+// over budget or cancelled.
+func (i *Interpreter) step(stmt ast.Stmt) {
+	i.steps++
+	if max := i.limits.MaxSteps; max > 0 && i.steps > max {
+		panic(stmtError(stmt, "Execution limit exceeded.", ErrStepLimit))
+	}
+	if i.steps%contextCheckInterval == 0 {
+		if err := i.ctx.Err(); err != nil {
+			panic(stmtError(stmt, "Execution interrupted: "+err.Error()+".", err))
+		}
+	}
+}
+
+func stmtError(stmt ast.Stmt, msg string, cause error) *loxerr.RuntimeError {
+	span := stmt.Span()
+	return &loxerr.RuntimeError{
+		Position: span.Start,
+		End:      span.End,
+		Msg:      msg,
+		Cause:    cause,
+	}
+}
@@ -0,0 +1,283 @@
+package interpreter
+
+import (
+	"fmt"
+	"lox/token"
+	"sort"
+	"strings"
+)
+
+var (
+	_ Object    = (*List)(nil)
+	_ Indexable = (*List)(nil)
+)
+
+// Indexable is a value whose elements are read and written with the
+// subscript syntax: xs[i] and xs[i] = v.
+type Indexable interface {
+	Index(bracket *token.Token, index any) any
+	SetIndex(bracket *token.Token, index any, value any)
+}
+
+// List is a growable array of Lox values, created by a list literal.
+type List struct {
+	elements []any
+}
+
+func NewList(elements []any) *List {
+	return &List{elements: elements}
+}
+
+// Elements returns the elements of the list. The slice is shared with l.
+func (l *List) Elements() []any {
+	return l.elements
+}
+
+func (l *List) String() string {
+	return l.format(map[any]bool{})
+}
+
+// format prints l, or [...] when l is in seen: a list that contains itself
+// would otherwise be printed forever.
+func (l *List) format(seen map[any]bool) string {
+	if seen[l] {
+		return "[...]"
+	}
+	seen[l] = true
+	defer delete(seen, l)
+
+	parts := make([]string, len(l.elements))
+	for i, e := range l.elements {
+		parts[i] = quote(e, seen)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func (l *List) Index(bracket *token.Token, index any) any {
+	return l.elements[l.checkIndex(bracket, index)]
+}
+
+func (l *List) SetIndex(bracket *token.Token, index any, value any) {
+	l.elements[l.checkIndex(bracket, index)] = value
+}
+
+func (l *List) checkIndex(bracket *token.Token, index any) int {
+	n, ok := index.(float64)
+	if !ok || n != float64(int(n)) {
+		panic(runtimeError(bracket, "List index must be an integer."))
+	}
+	if n < 0 || int(n) >= len(l.elements) {
+		panic(runtimeError(bracket, fmt.Sprintf("List index %d out of range [0, %d).", int(n), len(l.elements))))
+	}
+	return int(n)
+}
+
+func (l *List) Get(name *token.Token) any {
+	if m := l.method(name.Lexeme()); m != nil {
+		return m
+	}
+	panic(runtimeError(name, fmt.Sprintf("Undefined property '%s'.", name.Lexeme())))
+}
+
+func (l *List) Set(name *token.Token, value any) {
+	panic(runtimeError(name, "Only instances have fields."))
+}
+
+func (l *List) method(name string) *method {
+	m := &method{name: name}
+	switch name {
+	case "len":
+		m.fn = func(rt Runtime, args []any) (any, error) {
+			return float64(len(l.elements)), nil
+		}
+	case "push":
+		m.arity = 1
+		m.fn = func(rt Runtime, args []any) (any, error) {
+			l.elements = append(l.elements, args[0])
+			return nil, nil
+		}
+	case "pop":
+		m.fn = func(rt Runtime, args []any) (any, error) {
+			if len(l.elements) == 0 {
+				return nil, fmt.Errorf("Can't pop from an empty list.")
+			}
+			last := l.elements[len(l.elements)-1]
+			l.elements = l.elements[:len(l.elements)-1]
+			return last, nil
+		}
+	case "insert":
+		m.arity = 2
+		m.fn = func(rt Runtime, args []any) (any, error) {
+			at, err := l.position(args, 0, len(l.elements))
+			if err != nil {
+				return nil, err
+			}
+			l.elements = append(l.elements, nil)
+			copy(l.elements[at+1:], l.elements[at:])
+			l.elements[at] = args[1]
+			return nil, nil
+		}
+	case "remove":
+		m.arity = 1
+		m.fn = func(rt Runtime, args []any) (any, error) {
+			at, err := l.position(args, 0, len(l.elements)-1)
+			if err != nil {
+				return nil, err
+			}
+			removed := l.elements[at]
+			l.elements = append(l.elements[:at], l.elements[at+1:]...)
+			return removed, nil
+		}
+	case "slice":
+		m.arity = 2
+		m.fn = func(rt Runtime, args []any) (any, error) {
+			start, err := l.position(args, 0, len(l.elements))
+			if err != nil {
+				return nil, err
+			}
+			end, err := l.position(args, 1, len(l.elements))
+			if err != nil {
+				return nil, err
+			}
+			if end < start {
+				return nil, fmt.Errorf("Slice end %d is before start %d.", end, start)
+			}
+			return NewList(append([]any(nil), l.elements[start:end]...)), nil
+		}
+	case "map":
+		m.arity = 1
+		m.fn = func(rt Runtime, args []any) (any, error) {
+			fn, err := ArgCallable(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			mapped := make([]any, len(l.elements))
+			for n, e := range l.elements {
+				if mapped[n], err = rt.Callback(fn, e); err != nil {
+					return nil, err
+				}
+			}
+			return NewList(mapped), nil
+		}
+	case "filter":
+		m.arity = 1
+		m.fn = func(rt Runtime, args []any) (any, error) {
+			fn, err := ArgCallable(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			kept := []any{}
+			for _, e := range l.elements {
+				keep, err := rt.Callback(fn, e)
+				if err != nil {
+					return nil, err
+				}
+				if isTruthy(keep) {
+					kept = append(kept, e)
+				}
+			}
+			return NewList(kept), nil
+		}
+	case "reduce":
+		m.arity = 2
+		m.fn = func(rt Runtime, args []any) (any, error) {
+			fn, err := ArgCallable(args, 0)
+			if err != nil {
+				return nil, err
+			}
+			acc := args[1]
+			for _, e := range l.elements {
+				if acc, err = rt.Callback(fn, acc, e); err != nil {
+					return nil, err
+				}
+			}
+			return acc, nil
+		}
+	case "sort":
+		m.arity = Variadic
+		m.fn = l.sort
+	default:
+		return nil
+	}
+	return m
+}
+
+// sort implements sort() and sort(compare). Without compare, the list must
+// hold only numbers or only strings. compare(a, b) returns a negative number
+// when a goes before b.
+func (l *List) sort(rt Runtime, args []any) (any, error) {
+	var less func(a, b any) (bool, error)
+	switch len(args) {
+	case 0:
+		less = naturalLess
+	case 1:
+		fn, err := ArgCallable(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		less = func(a, b any) (bool, error) {
+			order, err := rt.Callback(fn, a, b)
+			if err != nil {
+				return false, err
+			}
+			n, ok := order.(float64)
+			if !ok {
+				return false, fmt.Errorf("Comparison function must return a number.")
+			}
+			return n < 0, nil
+		}
+	default:
+		return nil, fmt.Errorf("Expected 0 or 1 arguments but got %d.", len(args))
+	}
+
+	var sortErr error
+	sort.SliceStable(l.elements, func(a, b int) bool {
+		if sortErr != nil {
+			return false
+		}
+		isLess, err := less(l.elements[a], l.elements[b])
+		sortErr = err
+		return isLess
+	})
+	return nil, sortErr
+}
+
+func naturalLess(a, b any) (bool, error) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			return a < b, nil
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return a < b, nil
+		}
+	}
+	return false, fmt.Errorf("Can only sort numbers or strings without a comparison function.")
+}
+
+// position reads args[n] as a position in the list, between 0 and max.
+func (l *List) position(args []any, n int, max int) (int, error) {
+	at, err := ArgInt(args, n)
+	if err != nil {
+		return 0, err
+	}
+	if at < 0 || at > max {
+		return 0, fmt.Errorf("List index %d out of range [0, %d).", at, max+1)
+	}
+	return at, nil
+}
+
+// quote formats v as an element of a collection, where strings show their
+// quotes. seen holds the collections being printed.
+func quote(v any, seen map[any]bool) string {
+	switch v := v.(type) {
+	case string:
+		return `"` + v + `"`
+	case *List:
+		return v.format(seen)
+	case *Map:
+		return v.format(seen)
+	}
+	return Stringify(v)
+}
@@ -0,0 +1,176 @@
+package interpreter
+
+import (
+	"fmt"
+	"lox/token"
+	"math"
+	"strings"
+)
+
+var (
+	_ Object    = (*Map)(nil)
+	_ Indexable = (*Map)(nil)
+)
+
+// Map is a hash map from Lox values to Lox values, created by a map literal.
+// It remembers the order in which keys were first inserted.
+type Map struct {
+	entries map[any]any
+	keys    []any
+}
+
+func NewMap() *Map {
+	return &Map{entries: make(map[any]any)}
+}
+
+// Len returns the number of entries.
+func (m *Map) Len() int {
+	return len(m.keys)
+}
+
+// Keys returns the keys in insertion order. The slice is shared with m.
+func (m *Map) Keys() []any {
+	return m.keys
+}
+
+// Lookup returns the value stored under key.
+func (m *Map) Lookup(key any) (any, bool) {
+	if !hashable(key) {
+		return nil, false
+	}
+	v, ok := m.entries[key]
+	return v, ok
+}
+
+// Put stores value under key. Keys must be strings, numbers other than NaN,
+// booleans, nil or instances; instances are compared by identity.
+func (m *Map) Put(key any, value any) error {
+	if !hashable(key) {
+		return m.keyError(key)
+	}
+	if _, ok := m.entries[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.entries[key] = value
+	return nil
+}
+
+// Delete removes key, reporting whether it was present.
+func (m *Map) Delete(key any) bool {
+	if _, ok := m.Lookup(key); !ok {
+		return false
+	}
+	delete(m.entries, key)
+	for n, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:n], m.keys[n+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func (m *Map) String() string {
+	return m.format(map[any]bool{})
+}
+
+// format prints m, or {...} when m is in seen, like List.format.
+func (m *Map) format(seen map[any]bool) string {
+	if seen[m] {
+		return "{...}"
+	}
+	seen[m] = true
+	defer delete(seen, m)
+
+	parts := make([]string, len(m.keys))
+	for n, k := range m.keys {
+		parts[n] = quote(k, seen) + ": " + quote(m.entries[k], seen)
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// Index returns the value stored under index, or nil when there is none.
+func (m *Map) Index(bracket *token.Token, index any) any {
+	if !hashable(index) {
+		panic(runtimeError(bracket, m.keyError(index).Error()))
+	}
+	return m.entries[index]
+}
+
+func (m *Map) SetIndex(bracket *token.Token, index any, value any) {
+	if err := m.Put(index, value); err != nil {
+		panic(runtimeError(bracket, err.Error()))
+	}
+}
+
+func (m *Map) keyError(key any) error {
+	if n, ok := key.(float64); ok && math.IsNaN(n) {
+		return fmt.Errorf("Map key can't be nan.")
+	}
+	return fmt.Errorf("Map key must be a string, number, boolean, nil or instance, not %s.", Stringify(key))
+}
+
+func (m *Map) Get(name *token.Token) any {
+	if method := m.method(name.Lexeme()); method != nil {
+		return method
+	}
+	panic(runtimeError(name, fmt.Sprintf("Undefined property '%s'.", name.Lexeme())))
+}
+
+func (m *Map) Set(name *token.Token, value any) {
+	panic(runtimeError(name, "Only instances have fields."))
+}
+
+func (m *Map) method(name string) *method {
+	method := &method{name: name}
+	switch name {
+	case "len":
+		method.fn = func(rt Runtime, args []any) (any, error) {
+			return float64(m.Len()), nil
+		}
+	case "has":
+		method.arity = 1
+		method.fn = func(rt Runtime, args []any) (any, error) {
+			if !hashable(args[0]) {
+				return nil, m.keyError(args[0])
+			}
+			_, ok := m.entries[args[0]]
+			return ok, nil
+		}
+	case "delete":
+		method.arity = 1
+		method.fn = func(rt Runtime, args []any) (any, error) {
+			if !hashable(args[0]) {
+				return nil, m.keyError(args[0])
+			}
+			return m.Delete(args[0]), nil
+		}
+	case "keys":
+		method.fn = func(rt Runtime, args []any) (any, error) {
+			return NewList(append([]any(nil), m.keys...)), nil
+		}
+	case "values":
+		method.fn = func(rt Runtime, args []any) (any, error) {
+			values := make([]any, len(m.keys))
+			for n, k := range m.keys {
+				values[n] = m.entries[k]
+			}
+			return NewList(values), nil
+		}
+	default:
+		return nil
+	}
+	return method
+}
+
+// hashable reports whether v can be a map key.
+func hashable(v any) bool {
+	switch v := v.(type) {
+	case float64:
+		// NaN is not equal to itself, so it could never be found again.
+		return !math.IsNaN(v)
+	case nil, bool, string, *Instance:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package interpreter
+
+import (
+	"fmt"
+	"lox/ast"
+	"lox/env"
+	"lox/token"
+)
+
+var _ Object = (*Module)(nil)
+
+// Module is the value of an imported file. Its properties are the file's
+// top-level declarations.
+type Module struct {
+	name    string
+	globals *env.Env
+}
+
+// NewModule returns the module name, whose members are the variables
+// defined in globals.
+func NewModule(name string, globals *env.Env) *Module {
+	return &Module{name: name, globals: globals}
+}
+
+func (m *Module) Name() string {
+	return m.name
+}
+
+func (m *Module) String() string {
+	return "<module " + m.name + ">"
+}
+
+func (m *Module) Get(name *token.Token) any {
+	val, ok := m.globals.Lookup(name.Lexeme())
+	if !ok {
+		panic(runtimeError(name, fmt.Sprintf("Module '%s' has no member '%s'.", m.name, name.Lexeme())))
+	}
+	return val
+}
+
+func (m *Module) Set(name *token.Token, value any) {
+	panic(runtimeError(name, "Can't assign to a module member."))
+}
+
+// Importer loads the module at path for an import statement in the file
+// from. It usually reads, scans, parses and resolves the file and runs it
+// with RunModule, caching the result so that every module runs once, and
+// detects import cycles.
+type Importer func(from string, path string) (*Module, error)
+
+// SetImporter makes import statements load modules with imp. Without an
+// importer, importing fails with a runtime error.
+func (i *Interpreter) SetImporter(imp Importer) {
+	i.importer = imp
+}
+
+// RunModule runs the resolved top-level statements of the module name in a
+// fresh global environment, enclosed by the built-ins. Runtime errors in the
+// module unwind to the import statement.
+func (i *Interpreter) RunModule(name string, stmts []ast.Stmt) (*Module, error) {
+	m := NewModule(name, env.NewGlobal(i.builtins))
+	prevEnv, prevGlobals := i.env, i.globals
+	i.env, i.globals = m.globals, m.globals
+
+	for _, stmt := range stmts {
+		i.execute(stmt)
+	}
+
+	i.env, i.globals = prevEnv, prevGlobals
+	return m, nil
+}
+
+func (i *Interpreter) VisitImportStmt(stmt *ast.ImportStmt) any {
+	if i.importer == nil {
+		panic(runtimeError(stmt.Path, "Imports are not supported."))
+	}
+
+	// The module's top-level code shows up in stack traces as a call made
+	// by the import.
+	path := stmt.Path.Literal().(string)
+	i.frames = append(i.frames, frame{function: "<module " + path + ">", callSite: stmt.Path})
+	m, err := i.importer(stmt.Path.Pos().File, path)
+	i.frames = i.frames[:len(i.frames)-1]
+	if err != nil {
+		panic(runtimeError(stmt.Path, err.Error()))
+	}
+
+	if stmt.Alias != nil {
+		i.env.Define(stmt.Alias.Lexeme(), m)
+		return nil
+	}
+	for _, name := range stmt.Names {
+		i.env.Define(name.Lexeme(), m.Get(name))
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package interpreter
+
+import (
+	"fmt"
+	"lox/loxerr"
+)
+
+var (
+	_ Builtin = (*Native)(nil)
+	_ Builtin = (*goFunc)(nil)
+	_ Builtin = (*method)(nil)
+)
+
+// Variadic is the arity of a native function accepting any number of
+// arguments.
+const Variadic = -1
+
+// NativeFunc implements a native function in Go. A returned error becomes a
+// Lox runtime error at the call site.
+type NativeFunc func(args []any) (any, error)
+
+// Builtin is a function implemented in Go. Runtimes report its errors at
+// the Lox call site rather than inside the function.
+type Builtin interface {
+	Callable
+	CallBuiltin(rt Runtime, arguments []any) (any, error)
+}
+
+// Native is a function provided by the host program.
+type Native struct {
+	name  string
+	arity int
+	fn    NativeFunc
+}
+
+// NewNative returns a native function taking arity arguments, or any number
+// of them when arity is Variadic. Go numbers returned by fn are converted to
+// Lox numbers.
+func NewNative(name string, arity int, fn NativeFunc) *Native {
+	return &Native{
+		name:  name,
+		arity: arity,
+		fn:    fn,
+	}
+}
+
+func (n *Native) Arity() int {
+	return n.arity
+}
+
+func (n *Native) Call(rt Runtime, arguments []any) any {
+	val, err := n.CallBuiltin(rt, arguments)
+	if err != nil {
+		panic(&loxerr.RuntimeError{Msg: err.Error(), Cause: err})
+	}
+	return val
+}
+
+func (n *Native) CallBuiltin(rt Runtime, arguments []any) (any, error) {
+	val, err := n.fn(arguments)
+	if err != nil {
+		return nil, err
+	}
+	return FromGo(val), nil
+}
+
+func (n *Native) Name() string {
+	return n.name
+}
+
+func (n *Native) String() string {
+	return "<native fn>"
+}
+
+// method is a built-in method of a Lox value, such as push on a list. Unlike
+// a Native it may call back into the runtime.
+type method struct {
+	name  string
+	arity int
+	fn    func(rt Runtime, args []any) (any, error)
+}
+
+func (m *method) Arity() int {
+	return m.arity
+}
+
+func (m *method) Call(rt Runtime, arguments []any) any {
+	val, err := m.CallBuiltin(rt, arguments)
+	if err != nil {
+		panic(&loxerr.RuntimeError{Msg: err.Error(), Cause: err})
+	}
+	return val
+}
+
+func (m *method) CallBuiltin(rt Runtime, arguments []any) (any, error) {
+	return m.fn(rt, arguments)
+}
+
+func (m *method) String() string {
+	return "<native fn>"
+}
+
+// ArgNumber returns args[i] as a number.
+func ArgNumber(args []any, i int) (float64, error) {
+	v, ok := arg(args, i).(float64)
+	if !ok {
+		return 0, argError(i, "a number")
+	}
+	return v, nil
+}
+
+// ArgInt returns args[i] as an int. The argument must be a whole number.
+func ArgInt(args []any, i int) (int, error) {
+	v, ok := arg(args, i).(float64)
+	if !ok || v != float64(int(v)) {
+		return 0, argError(i, "an integer")
+	}
+	return int(v), nil
+}
+
+// ArgString returns args[i] as a string.
+func ArgString(args []any, i int) (string, error) {
+	v, ok := arg(args, i).(string)
+	if !ok {
+		return "", argError(i, "a string")
+	}
+	return v, nil
+}
+
+// ArgBool returns args[i] as a boolean.
+func ArgBool(args []any, i int) (bool, error) {
+	v, ok := arg(args, i).(bool)
+	if !ok {
+		return false, argError(i, "a boolean")
+	}
+	return v, nil
+}
+
+// ArgCallable returns args[i] as a function or class.
+func ArgCallable(args []any, i int) (Callable, error) {
+	v, ok := arg(args, i).(Callable)
+	if !ok {
+		return nil, argError(i, "a function")
+	}
+	return v, nil
+}
+
+func arg(args []any, i int) any {
+	if i < 0 || i >= len(args) {
+		return nil
+	}
+	return args[i]
+}
+
+func argError(i int, want string) error {
+	return fmt.Errorf("Argument %d must be %s.", i+1, want)
+}
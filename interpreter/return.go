@@ -1,5 +0,0 @@
-package interpreter
-
-type Return struct {
-	Value any
-}
@@ -0,0 +1,44 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Stringify formats a Lox value the way print shows it.
+func Stringify(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return formatNumber(v)
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	return fmt.Sprint(v)
+}
+
+// formatNumber prints integers without a fractional part ("3", not "3.0" or
+// "3e+00") and switches to exponent notation only for very large or small
+// magnitudes.
+func formatNumber(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "nan"
+	case math.IsInf(v, 1):
+		return "inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	}
+
+	if abs := math.Abs(v); abs != 0 && (abs >= 1e21 || abs < 1e-7) {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,465 @@
+// Package lox embeds the Lox interpreter in Go programs.
+//
+// Each VM owns its globals, resolved locals and I/O streams, so several
+// VMs can run scripts concurrently without seeing each other's state:
+//
+//	vm := lox.NewVM(lox.Options{Stdout: &buf})
+//	if _, err := vm.Eval(`fun add(a, b) { return a + b; }`); err != nil {
+//		return err
+//	}
+//	sum, err := vm.Call("add", 1, 2) // 3.0
+package lox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"lox/ast"
+	"lox/bytecode"
+	"lox/compiler"
+	"lox/interpreter"
+	"lox/loxerr"
+	"lox/parser"
+	"lox/resolver"
+	"lox/scanner"
+	"lox/vm"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// evalFileName names the source of Eval in error messages.
+const evalFileName = "<eval>"
+
+// Options configures a VM. The zero value is ready to use.
+type Options struct {
+	// Stdout receives the output of print statements. Defaults to os.Stdout.
+	Stdout io.Writer
+
+	// Stderr receives the output of the printErr(value) built-in. Defaults
+	// to os.Stderr.
+	Stderr io.Writer
+
+	// Stdin is read by the readLine() built-in. Defaults to os.Stdin.
+	Stdin io.Reader
+
+	// Args are the script arguments, exposed through the argc global and
+	// the argv(i) built-in.
+	Args []string
+
+	// MaxSteps bounds the statements one Eval or Call may execute, or the
+	// instructions with the Bytecode backend. Zero means no limit.
+	MaxSteps int
+
+	// MaxCallDepth bounds nested Lox calls; deeper recursion fails with
+	// "Stack overflow.". Zero means interpreter.DefaultMaxCallDepth and a
+	// negative value means no limit.
+	MaxCallDepth int
+
+	// Timeout bounds the wall-clock time of one Eval or Call. Zero means no
+	// limit.
+	Timeout time.Duration
+
+	// ReadFile reads the modules loaded by import statements. Defaults to
+	// os.ReadFile; set it to restrict or virtualize what scripts can import.
+	ReadFile func(name string) ([]byte, error)
+
+	// Backend selects how scripts run. Defaults to TreeWalker.
+	Backend Backend
+}
+
+// Backend is a way of running Lox code. Programs behave the same on both,
+// with the same output and errors; the backends differ in speed and in how
+// MaxSteps counts.
+type Backend int
+
+const (
+	// TreeWalker interprets the syntax tree directly.
+	TreeWalker Backend = iota
+
+	// Bytecode compiles scripts to bytecode run by a stack machine.
+	Bytecode
+)
+
+// engine runs resolved programs: the tree-walking interpreter or the
+// bytecode VM.
+type engine interface {
+	resolver.Locals
+	SetStdout(w io.Writer)
+	SetStderr(w io.Writer)
+	SetStdin(r io.Reader)
+	SetLimits(limits interpreter.Limits)
+	SetImporter(imp interpreter.Importer)
+	Start(ctx context.Context) error
+	DefineGlobal(name string, val any)
+	DefineBuiltin(name string, val any)
+	DefineNative(name string, arity int, fn interpreter.NativeFunc)
+	GetGlobal(name string) (any, bool)
+	Interpret(stmts []ast.Stmt) error
+	Evaluate(expr ast.Expr) (any, error)
+	Call(callee interpreter.Callable, args []any) (any, error)
+	RunModule(name string, stmts []ast.Stmt) (*interpreter.Module, error)
+}
+
+// bytecodeEngine is an engine that runs compiled scripts.
+type bytecodeEngine interface {
+	engine
+	Run(fn *bytecode.Function) error
+}
+
+// VM is an isolated Lox interpreter. Its methods may be called from several
+// goroutines; calls on one VM run one at a time.
+type VM struct {
+	mu          sync.Mutex
+	interpreter engine
+	timeout     time.Duration
+	readFile    func(name string) ([]byte, error)
+	modules     map[string]*interpreter.Module // by file name
+
+	// script is the file name of the script running, and loading the
+	// modules it is loading, outermost first.
+	script  string
+	loading []string
+}
+
+func NewVM(opts Options) *VM {
+	var i engine = interpreter.New()
+	if opts.Backend == Bytecode {
+		i = vm.New()
+	}
+	if opts.Stdout != nil {
+		i.SetStdout(opts.Stdout)
+	}
+	if opts.Stderr != nil {
+		i.SetStderr(opts.Stderr)
+	}
+	if opts.Stdin != nil {
+		i.SetStdin(opts.Stdin)
+	}
+	i.DefineBuiltin("argc", float64(len(opts.Args)))
+	i.DefineNative("argv", 1, argv(opts.Args))
+
+	limits := interpreter.Limits{
+		MaxSteps:     opts.MaxSteps,
+		MaxCallDepth: opts.MaxCallDepth,
+	}
+	switch {
+	case limits.MaxCallDepth == 0:
+		limits.MaxCallDepth = interpreter.DefaultMaxCallDepth
+	case limits.MaxCallDepth < 0:
+		limits.MaxCallDepth = 0
+	}
+	i.SetLimits(limits)
+
+	vm := &VM{
+		interpreter: i,
+		timeout:     opts.Timeout,
+		readFile:    opts.ReadFile,
+		modules:     make(map[string]*interpreter.Module),
+	}
+	if vm.readFile == nil {
+		vm.readFile = os.ReadFile
+	}
+	i.SetImporter(vm.importModule)
+
+	return vm
+}
+
+// Eval runs src in the VM. Declarations persist across calls. When the last
+// statement of src is an expression statement, its value is returned.
+func (vm *VM) Eval(src string) (any, error) {
+	return vm.EvalSourceContext(context.Background(), evalFileName, src)
+}
+
+// EvalContext is like Eval, stopping with a runtime error once ctx is done.
+// The error wraps ctx.Err().
+func (vm *VM) EvalContext(ctx context.Context, src string) (any, error) {
+	return vm.EvalSourceContext(ctx, evalFileName, src)
+}
+
+// EvalSource is like Eval, with name used as the file name in errors.
+//
+// Scan, parse and resolve errors are returned as a loxerr.List and nothing is
+// run; a failure while running is returned as a *loxerr.RuntimeError.
+func (vm *VM) EvalSource(name string, src string) (any, error) {
+	return vm.EvalSourceContext(context.Background(), name, src)
+}
+
+// EvalSourceContext is like EvalSource, stopping with a runtime error once
+// ctx is done.
+func (vm *VM) EvalSourceContext(ctx context.Context, name string, src string) (any, error) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	stmts, err := vm.compile(name, src)
+	if err != nil {
+		return nil, err
+	}
+	return vm.eval(ctx, name, stmts)
+}
+
+// EvalExpression evaluates src as a single expression, without a trailing
+// semicolon, and returns its value or an error as EvalSource does. Unlike
+// with EvalSource, an expression starting with '{', such as a map literal,
+// is not read as a block.
+func (vm *VM) EvalExpression(name string, src string) (any, error) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	expr, err := parseExpression(name, src, vm.interpreter)
+	if err != nil {
+		return nil, err
+	}
+	return vm.eval(context.Background(), name, []ast.Stmt{expr})
+}
+
+// eval runs the compiled statements of the script name, returning the value
+// of the last one when it is an expression statement.
+func (vm *VM) eval(ctx context.Context, name string, stmts []ast.Stmt) (any, error) {
+	vm.script = filepath.Clean(name)
+	cancel, err := vm.start(ctx)
+	defer cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	last, ok := lastExpression(stmts)
+	if !ok {
+		return nil, vm.interpreter.Interpret(stmts)
+	}
+
+	if err := vm.interpreter.Interpret(stmts[:len(stmts)-1]); err != nil {
+		return nil, err
+	}
+	return vm.interpreter.Evaluate(last)
+}
+
+// Call calls the global function or class name with args, converted like
+// SetGlobal values.
+func (vm *VM) Call(name string, args ...any) (any, error) {
+	return vm.CallContext(context.Background(), name, args...)
+}
+
+// CallContext is like Call, stopping with a runtime error once ctx is done.
+func (vm *VM) CallContext(ctx context.Context, name string, args ...any) (any, error) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	val, ok := vm.interpreter.GetGlobal(name)
+	if !ok {
+		return nil, &loxerr.RuntimeError{Msg: fmt.Sprintf("Undefined variable '%s'.", name)}
+	}
+
+	callee, ok := val.(interpreter.Callable)
+	if !ok {
+		return nil, &loxerr.RuntimeError{Msg: "Can only call functions and classes."}
+	}
+
+	loxArgs := make([]any, len(args))
+	for i, arg := range args {
+		loxArgs[i] = interpreter.FromGo(arg)
+	}
+
+	cancel, err := vm.start(ctx)
+	defer cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	return vm.interpreter.Call(callee, loxArgs)
+}
+
+// SetGlobal defines the global variable name. Go numbers become Lox numbers,
+// Go functions become callables, and struct pointers, maps and slices are
+// bound through reflection: scripts read and write their exported fields and
+// call their exported methods.
+func (vm *VM) SetGlobal(name string, val any) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.interpreter.DefineGlobal(name, interpreter.FromGo(val))
+}
+
+// GetGlobal returns the value of the global variable name.
+func (vm *VM) GetGlobal(name string) (any, bool) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	return vm.interpreter.GetGlobal(name)
+}
+
+// RegisterFunc defines fn as the global native function name, taking arity
+// arguments or any number of them when arity is Variadic. An error returned
+// by fn becomes a Lox runtime error at the call site, and Go numbers it
+// returns become Lox numbers.
+func (vm *VM) RegisterFunc(name string, arity int, fn Func) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.interpreter.DefineNative(name, arity, fn)
+}
+
+// Compile compiles the script src, named name in errors, to bytecode that
+// RunCompiled runs without parsing it again. It fails like EvalSource, and
+// also with a *loxerr.CompileError in the loxerr.List when src exceeds the
+// limits of the bytecode format.
+func Compile(name string, src string) (*bytecode.Function, error) {
+	locals := compiler.Locals{}
+	stmts, err := parse(name, src, locals)
+	if err != nil {
+		return nil, err
+	}
+	return compiler.New(locals).Compile(compiler.ScriptName, stmts)
+}
+
+// RunCompiled runs a script compiled by Compile. It needs a VM with the
+// Bytecode backend.
+func (vm *VM) RunCompiled(fn *bytecode.Function) error {
+	return vm.RunCompiledContext(context.Background(), fn)
+}
+
+// RunCompiledContext is like RunCompiled, stopping with a runtime error once
+// ctx is done.
+func (vm *VM) RunCompiledContext(ctx context.Context, fn *bytecode.Function) error {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	runner, ok := vm.interpreter.(bytecodeEngine)
+	if !ok {
+		return errors.New("compiled scripts need the Bytecode backend")
+	}
+
+	vm.script = filepath.Clean(fn.File())
+	cancel, err := vm.start(ctx)
+	defer cancel()
+	if err != nil {
+		return err
+	}
+
+	return runner.Run(fn)
+}
+
+// compile scans, parses and resolves src for the VM.
+func (vm *VM) compile(name string, src string) ([]ast.Stmt, error) {
+	return parse(name, src, vm.interpreter)
+}
+
+// parse scans, parses and resolves src, recording the references to local
+// variables in locals.
+func parse(name string, src string, locals resolver.Locals) ([]ast.Stmt, error) {
+	tokens, err := scanner.NewScanner(name, []rune(src)).ScanTokens()
+	if err != nil {
+		return nil, err
+	}
+
+	stmts, err := parser.New(tokens).ParserStmt()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := resolver.NewResolver(locals).Resolve(stmts); err != nil {
+		return nil, err
+	}
+	return stmts, nil
+}
+
+// parseExpression is like parse for src holding a single expression, which
+// it returns as an expression statement.
+func parseExpression(name string, src string, locals resolver.Locals) (ast.Stmt, error) {
+	tokens, err := scanner.NewScanner(name, []rune(src)).ScanTokens()
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := parser.New(tokens).Parser()
+	if err != nil {
+		return nil, loxerr.List{err}
+	}
+
+	stmt := &ast.ExpressionStmt{Expression: expr}
+	stmt.SetSpan(expr.Span())
+	if err := resolver.NewResolver(locals).Resolve([]ast.Stmt{stmt}); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// importModule loads the module at path, relative to the directory of the
+// importing file from. Each file runs once per VM. It fails when the file is
+// the script running or a module being loaded, which means the imports form
+// a cycle.
+func (vm *VM) importModule(from string, path string) (*interpreter.Module, error) {
+	name := path
+	if !filepath.IsAbs(path) {
+		name = filepath.Join(filepath.Dir(from), path)
+	}
+	if m, ok := vm.modules[name]; ok {
+		return m, nil
+	}
+
+	chain := append([]string{vm.script}, vm.loading...)
+	for n, loading := range chain {
+		if loading == name {
+			cycle := append(chain[n:], name)
+			return nil, fmt.Errorf("Import cycle: %s.", strings.Join(cycle, " -> "))
+		}
+	}
+
+	src, err := vm.readFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read module '%s'.", path)
+	}
+	stmts, err := vm.compile(name, string(src))
+	if err != nil {
+		return nil, fmt.Errorf("Can't compile module '%s':\n%s", path, err)
+	}
+
+	// A runtime error in the module unwinds through here to the import
+	// statement, so the module is popped either way.
+	vm.loading = append(vm.loading, name)
+	defer func() { vm.loading = vm.loading[:len(vm.loading)-1] }()
+
+	m, err := vm.interpreter.RunModule(name, stmts)
+	if err != nil {
+		return nil, err
+	}
+	vm.modules[name] = m
+	return m, nil
+}
+
+// start begins a run of the interpreter under ctx and the VM's timeout. It
+// fails when ctx is done already.
+func (vm *VM) start(ctx context.Context) (context.CancelFunc, error) {
+	cancel := context.CancelFunc(func() {})
+	if vm.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, vm.timeout)
+	}
+	return cancel, vm.interpreter.Start(ctx)
+}
+
+// argv implements argv(i), returning the i-th script argument as a string,
+// or nil when i is not a valid index.
+func argv(args []string) Func {
+	return func(arguments []any) (any, error) {
+		index, err := ArgInt(arguments, 0)
+		if err != nil || index < 0 || index >= len(args) {
+			return nil, nil
+		}
+		return args[index], nil
+	}
+}
+
+func lastExpression(stmts []ast.Stmt) (ast.Expr, bool) {
+	if len(stmts) == 0 {
+		return nil, false
+	}
+
+	stmt, ok := stmts[len(stmts)-1].(*ast.ExpressionStmt)
+	if !ok {
+		return nil, false
+	}
+	return stmt.Expression, true
+}
@@ -0,0 +1,482 @@
+package lox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"lox/bytecode"
+	"lox/interpreter"
+	"lox/loxerr"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVMsAreIsolated(t *testing.T) {
+	var out1, out2 bytes.Buffer
+	vm1 := NewVM(Options{Stdout: &out1})
+	vm2 := NewVM(Options{Stdout: &out2})
+
+	if _, err := vm1.Eval(`var shared = "vm1"; print shared;`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm2.Eval(`print shared;`); err == nil {
+		t.Fatal("vm2 sees a global declared in vm1")
+	}
+
+	if got := out1.String(); got != "vm1\n" {
+		t.Errorf("vm1 output = %q", got)
+	}
+	if got := out2.String(); got != "" {
+		t.Errorf("vm2 output = %q", got)
+	}
+}
+
+func TestEvalReturnsLastExpression(t *testing.T) {
+	vm := NewVM(Options{})
+	val, err := vm.Eval(`var a = 20; a + 22;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != 42.0 {
+		t.Errorf("Eval = %v, want 42", val)
+	}
+
+	val, err = vm.Eval(`var b = 1;`)
+	if err != nil || val != nil {
+		t.Errorf("Eval = %v, %v; want nil, nil", val, err)
+	}
+}
+
+func TestCallAndGlobals(t *testing.T) {
+	vm := NewVM(Options{})
+	vm.SetGlobal("offset", 10)
+	if _, err := vm.Eval(`fun add(a, b) { return a + b + offset; }`); err != nil {
+		t.Fatal(err)
+	}
+
+	sum, err := vm.Call("add", 1, 2.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != 13.5 {
+		t.Errorf("add(1, 2.5) = %v, want 13.5", sum)
+	}
+
+	if _, err := vm.Call("add", 1); err == nil {
+		t.Error("expected an arity error")
+	}
+	if _, err := vm.Call("missing"); err == nil {
+		t.Error("expected an undefined variable error")
+	}
+
+	if _, err := vm.Eval(`offset = "changed";`); err != nil {
+		t.Fatal(err)
+	}
+	if val, ok := vm.GetGlobal("offset"); !ok || val != "changed" {
+		t.Errorf("GetGlobal(offset) = %v, %v", val, ok)
+	}
+}
+
+func TestVMsRunConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			vm := NewVM(Options{})
+			val, err := vm.Eval(`
+				var total = 0;
+				for (var i = 0; i < 1000; i = i + 1) total = total + i;
+				total;`)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if val != 499500.0 {
+				t.Errorf("total = %v", val)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRegisterFunc(t *testing.T) {
+	var out bytes.Buffer
+	vm := NewVM(Options{Stdout: &out})
+	vm.RegisterFunc("repeat", 2, func(args []any) (any, error) {
+		s, err := ArgString(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		n, err := ArgInt(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return strings.Repeat(s, n), nil
+	})
+	vm.RegisterFunc("count", Variadic, func(args []any) (any, error) {
+		return len(args), nil
+	})
+
+	if _, err := vm.Eval(`print repeat("ab", 3); print count(); print count(1, 2, 3);`); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "ababab\n0\n3\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	_, err := vm.Eval("\nrepeat(\"ab\", 1.5);")
+	var rtErr *loxerr.RuntimeError
+	if !errors.As(err, &rtErr) {
+		t.Fatalf("err = %v, want a runtime error", err)
+	}
+	if rtErr.Msg != "Argument 2 must be an integer." || rtErr.Line != 2 {
+		t.Errorf("err = %v", err)
+	}
+
+	if _, err := vm.Eval(`repeat("ab");`); err == nil {
+		t.Error("expected an arity error")
+	}
+}
+
+type account struct {
+	Owner   string
+	Balance float64
+	Tags    []string
+	Address address
+	limit   int
+}
+
+type address struct {
+	City string
+}
+
+func (a *account) Deposit(amount float64) float64 {
+	a.Balance += amount
+	return a.Balance
+}
+
+func (a *account) Withdraw(amount float64) error {
+	if amount > a.Balance {
+		return fmt.Errorf("Insufficient funds.")
+	}
+	a.Balance -= amount
+	return nil
+}
+
+func TestGoObjects(t *testing.T) {
+	var out bytes.Buffer
+	vm := NewVM(Options{Stdout: &out})
+	acct := &account{Owner: "ada", Tags: []string{"a", "b"}}
+	vm.SetGlobal("acct", acct)
+	vm.SetGlobal("settings", map[string]int{"retries": 3})
+	vm.SetGlobal("upper", strings.ToUpper)
+
+	_, err := vm.Eval(`
+		print upper(acct.Owner);
+		acct.Owner = "grace";
+		print acct.Deposit(10);
+		acct.Withdraw(4);
+		print acct.Tags.len();
+		acct.Tags.set(1, "c");
+		print acct.Tags.get(1);
+		print settings.retries;
+		settings.retries = 5;
+		acct.Address.City = "Paris";
+		print acct.Address == acct.Address;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "ADA\n10\n2\nc\n3\ntrue\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if acct.Owner != "grace" || acct.Balance != 6 || acct.Tags[1] != "c" || acct.Address.City != "Paris" {
+		t.Errorf("acct = %+v", acct)
+	}
+
+	for src, want := range map[string]string{
+		`acct.Withdraw(100);`:    "Insufficient funds.",
+		`acct.limit;`:            "Undefined property 'limit'.",
+		`acct.Balance = "lots";`: "Cannot convert lots to float64.",
+		`acct.Tags.get(5);`:      "Index 5 out of range [0, 2).",
+	} {
+		_, err := vm.Eval(src)
+		var rtErr *loxerr.RuntimeError
+		if !errors.As(err, &rtErr) || rtErr.Msg != want {
+			t.Errorf("%s: err = %v, want %q", src, err, want)
+		}
+	}
+
+	val, err := vm.Eval(`acct;`)
+	if obj, ok := val.(*interpreter.GoObject); err != nil || !ok || obj.Value() != acct {
+		t.Errorf("acct = %v, %v", val, err)
+	}
+
+	if !interpreter.Equal(interpreter.NewGoObject(strings.ToUpper), interpreter.NewGoObject(strings.ToUpper)) {
+		t.Error("a Go function is not equal to itself")
+	}
+}
+
+func TestStreams(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	vm := NewVM(Options{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Stdin:  strings.NewReader("ada\r\nlovelace"),
+	})
+
+	_, err := vm.Eval(`
+		var line = readLine();
+		while (line != nil) {
+			print line;
+			line = readLine();
+		}
+		printErr("done");
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stdout.String(), "ada\nlovelace\n"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	if got, want := stderr.String(), "done\n"; got != want {
+		t.Errorf("stderr = %q, want %q", got, want)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		ctx  func() (context.Context, context.CancelFunc)
+		want string
+	}{
+		{
+			name: "steps",
+			opts: Options{MaxSteps: 1000},
+			want: "Execution limit exceeded.",
+		},
+		{
+			name: "timeout",
+			opts: Options{Timeout: 10 * time.Millisecond},
+			want: "Execution interrupted: context deadline exceeded.",
+		},
+		{
+			name: "context",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 10*time.Millisecond)
+			},
+			want: "Execution interrupted: context deadline exceeded.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if tt.ctx != nil {
+				ctx, cancel = tt.ctx()
+			}
+			defer cancel()
+
+			vm := NewVM(tt.opts)
+			// Scripts can't catch running out of steps or time.
+			_, err := vm.EvalContext(ctx, "var n = 0;\ntry { while (true) { n = n + 1; } } catch (e) {}")
+			var rtErr *loxerr.RuntimeError
+			if !errors.As(err, &rtErr) || rtErr.Msg != tt.want {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+			if tt.want != "Execution limit exceeded." && !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("err = %v, want it to wrap context.DeadlineExceeded", err)
+			}
+
+			// The limits apply to each run, not to the VM's lifetime.
+			if val, err := vm.EvalContext(context.Background(), "n = 1;"); err != nil || val != 1.0 {
+				t.Errorf("next run = %v, %v", val, err)
+			}
+		})
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, backend := range []Backend{TreeWalker, Bytecode} {
+		var out bytes.Buffer
+		vm := NewVM(Options{Stdout: &out, Backend: backend})
+		if _, err := vm.EvalContext(ctx, `print "ran";`); !errors.Is(err, context.Canceled) {
+			t.Errorf("backend %d: err = %v, want it to wrap context.Canceled", backend, err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("backend %d: output = %q, want nothing run", backend, out.String())
+		}
+	}
+}
+
+func TestMaxCallDepth(t *testing.T) {
+	// f(n) makes n nested calls. Both backends allow MaxCallDepth of them,
+	// whether the first call comes from a script or from Go.
+	for _, backend := range []Backend{TreeWalker, Bytecode} {
+		vm := NewVM(Options{MaxCallDepth: 50, Backend: backend})
+		if _, err := vm.Eval(`fun f(n) { if (n > 1) f(n - 1); }`); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := vm.Call("f", 50); err != nil {
+			t.Errorf("backend %d: f(50): %v", backend, err)
+		}
+		if _, err := vm.Eval(`f(50);`); err != nil {
+			t.Errorf("backend %d: f(50) from a script: %v", backend, err)
+		}
+
+		var rtErr *loxerr.RuntimeError
+		if _, err := vm.Call("f", 51); !errors.As(err, &rtErr) || rtErr.Msg != "Stack overflow." {
+			t.Errorf("backend %d: f(51): err = %v, want a stack overflow", backend, err)
+		}
+		if _, err := vm.Eval(`f(51);`); !errors.As(err, &rtErr) || rtErr.Msg != "Stack overflow." {
+			t.Errorf("backend %d: f(51) from a script: err = %v, want a stack overflow", backend, err)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	files := map[string]string{
+		"greet.lox": `fun greet(name) { return "hello " + name; }`,
+	}
+	var out bytes.Buffer
+	vm := NewVM(Options{
+		Stdout: &out,
+		ReadFile: func(name string) ([]byte, error) {
+			src, ok := files[name]
+			if !ok {
+				return nil, fmt.Errorf("no such module %q", name)
+			}
+			return []byte(src), nil
+		},
+	})
+
+	if _, err := vm.Eval(`from "greet.lox" import greet; print greet("lox");`); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "hello lox\n" {
+		t.Errorf("output = %q", got)
+	}
+
+	_, err := vm.Eval(`import "secret.lox" as secret;`)
+	var rtErr *loxerr.RuntimeError
+	if !errors.As(err, &rtErr) || rtErr.Msg != "Can't read module 'secret.lox'." {
+		t.Errorf("err = %v, want a runtime error reading secret.lox", err)
+	}
+}
+
+func TestBytecodeBackend(t *testing.T) {
+	var out bytes.Buffer
+	vm := NewVM(Options{Stdout: &out, Backend: Bytecode, MaxCallDepth: 50, MaxSteps: 10000})
+	acct := &account{Owner: "ada"}
+	vm.SetGlobal("acct", acct)
+	vm.RegisterFunc("double", 1, func(args []any) (any, error) {
+		n, err := ArgNumber(args, 0)
+		return 2 * n, err
+	})
+
+	val, err := vm.Eval(`
+		fun f(n) {
+			if (n > 0) return f(n - 1);
+			return acct.Deposit(double(2));
+		}
+		print [1, 2, 3].map(fun(x) { return x * x; });
+		f(10);
+	`)
+	if err != nil || val != 4.0 {
+		t.Fatalf("Eval = %v, %v", val, err)
+	}
+	if got, want := out.String(), "[1, 4, 9]\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if acct.Balance != 4 {
+		t.Errorf("acct = %+v", acct)
+	}
+
+	if val, err := vm.Call("f", 5); err != nil || val != 8.0 {
+		t.Errorf("f(5) = %v, %v", val, err)
+	}
+	_, err = vm.Call("f", 60)
+	var rtErr *loxerr.RuntimeError
+	if !errors.As(err, &rtErr) || rtErr.Msg != "Stack overflow." {
+		t.Errorf("f(60): err = %v, want a stack overflow", err)
+	}
+
+	_, err = vm.Eval("try { while (true) {} } catch (e) {}")
+	if !errors.As(err, &rtErr) || rtErr.Msg != "Execution limit exceeded." {
+		t.Errorf("err = %v, want the step limit", err)
+	}
+}
+
+func TestClosuresSurviveRuntimeErrors(t *testing.T) {
+	var out bytes.Buffer
+	vm := NewVM(Options{Stdout: &out, Backend: Bytecode})
+	_, err := vm.Eval(`var g; { var x = "captured"; g = fun() { return x; }; nil.boom; }`)
+	if err == nil {
+		t.Fatal("want a runtime error")
+	}
+	if _, err := vm.Eval(`print g(); { var y = "other"; print g(); }`); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "captured\ncaptured\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestCompiledScripts(t *testing.T) {
+	fn, err := Compile("greet.lox", `
+		fun greet(name) { return "hi " + name; }
+		print greet("ada");
+		print [0.5, nil, true].len();
+		throw Error("boom");
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var file bytes.Buffer
+	if err := bytecode.Encode(&file, fn); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := bytecode.Decode(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	vm := NewVM(Options{Stdout: &out, Backend: Bytecode})
+	err = vm.RunCompiled(loaded)
+	if got, want := out.String(), "hi ada\n3\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	var rtErr *loxerr.RuntimeError
+	if !errors.As(err, &rtErr) || rtErr.Position.File != "greet.lox" || rtErr.Position.Line != 5 {
+		t.Errorf("err = %v, want an error at greet.lox:5", err)
+	}
+
+	if err := NewVM(Options{}).RunCompiled(loaded); err == nil {
+		t.Error("RunCompiled on the tree-walker: want an error")
+	}
+
+	var listing bytes.Buffer
+	bytecode.Disassemble(&listing, loaded, "")
+	for _, want := range []string{"== script ==", "== greet ==", `CONSTANT            0 "hi "`} {
+		if !strings.Contains(listing.String(), want) {
+			t.Errorf("listing lacks %q:\n%s", want, listing.String())
+		}
+	}
+
+	for _, data := range []string{"", "garbage", file.String()[:10]} {
+		if _, err := bytecode.Decode(strings.NewReader(data)); !errors.Is(err, bytecode.ErrFormat) {
+			t.Errorf("Decode(%q) = %v, want ErrFormat", data, err)
+		}
+	}
+}
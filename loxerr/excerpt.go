@@ -0,0 +1,51 @@
+package loxerr
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Excerpt renders the source line on which err starts, with the range it
+// covers underlined:
+//
+//	print a + "b";
+//	        ^
+//
+// It returns "" when err carries no position inside source.
+func Excerpt(source string, err Spanned) string {
+	span := err.Span()
+	start := span.Start
+	if !start.IsValid() || start.Offset > len(source) {
+		return ""
+	}
+
+	lineStart := strings.LastIndexByte(source[:start.Offset], '\n') + 1
+	lineEnd := len(source)
+	if i := strings.IndexByte(source[start.Offset:], '\n'); i >= 0 {
+		lineEnd = start.Offset + i
+	}
+	line := source[lineStart:lineEnd]
+
+	// Underline up to the end of the span, or to the end of the line when
+	// the span runs over several lines.
+	end := span.End.Offset
+	if end > lineEnd || span.End.Line != start.Line {
+		end = lineEnd
+	}
+	width := utf8.RuneCountInString(source[start.Offset:max(end, start.Offset)])
+	if width == 0 {
+		width = 1
+	}
+
+	// Keep tabs so the carets line up with the source as printed.
+	var pad strings.Builder
+	for _, c := range source[lineStart:start.Offset] {
+		if c == '\t' {
+			pad.WriteRune('\t')
+		} else {
+			pad.WriteRune(' ')
+		}
+	}
+
+	return line + "\n" + pad.String() + strings.Repeat("^", width)
+}
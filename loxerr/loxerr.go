@@ -0,0 +1,219 @@
+// Package loxerr defines the errors reported by each stage of the pipeline:
+// scanning, parsing, resolving and running a program.
+package loxerr
+
+import (
+	"fmt"
+	"lox/token"
+	"strings"
+)
+
+var (
+	_ Spanned = (*ScanError)(nil)
+	_ Spanned = (*ParseError)(nil)
+	_ Spanned = (*ResolveError)(nil)
+	_ Spanned = (*CompileError)(nil)
+	_ Spanned = (*RuntimeError)(nil)
+	_ error   = (List)(nil)
+)
+
+// Spanned is an error that knows the source range it refers to.
+type Spanned interface {
+	error
+	Span() token.Span
+}
+
+// ScanError is an invalid character sequence found by the scanner.
+type ScanError struct {
+	token.Position
+	End token.Position
+	Msg string
+}
+
+func (e *ScanError) Span() token.Span {
+	return token.Span{Start: e.Position, End: e.End}
+}
+
+func (e *ScanError) Error() string {
+	return fmt.Sprintf("%s: Error: %s", e.Position, e.Msg)
+}
+
+// ParseError is a syntax error found by the parser.
+type ParseError struct {
+	token.Position
+	End   token.Position
+	Where string
+	Msg   string
+}
+
+func NewParseError(tok *token.Token, msg string) *ParseError {
+	return &ParseError{
+		Position: tok.Pos(),
+		End:      tok.End(),
+		Where:    where(tok),
+		Msg:      msg,
+	}
+}
+
+func (e *ParseError) Span() token.Span {
+	return token.Span{Start: e.Position, End: e.End}
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: Error%s: %s", e.Position, e.Where, e.Msg)
+}
+
+// ResolveError is a static error found by the resolver, such as returning
+// from top-level code.
+type ResolveError struct {
+	token.Position
+	End   token.Position
+	Where string
+	Msg   string
+}
+
+func NewResolveError(tok *token.Token, msg string) *ResolveError {
+	return &ResolveError{
+		Position: tok.Pos(),
+		End:      tok.End(),
+		Where:    where(tok),
+		Msg:      msg,
+	}
+}
+
+func (e *ResolveError) Span() token.Span {
+	return token.Span{Start: e.Position, End: e.End}
+}
+
+func (e *ResolveError) Error() string {
+	return fmt.Sprintf("%s: Error%s: %s", e.Position, e.Where, e.Msg)
+}
+
+// CompileError is a limit of the bytecode format exceeded by a program,
+// such as too many constants in one function.
+type CompileError struct {
+	token.Position
+	End token.Position
+	Msg string
+}
+
+func (e *CompileError) Span() token.Span {
+	return token.Span{Start: e.Position, End: e.End}
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("%s: Error: %s", e.Position, e.Msg)
+}
+
+// RuntimeError is raised while the interpreter executes a program.
+type RuntimeError struct {
+	token.Position
+	End token.Position
+	Msg string
+
+	// Trace lists the active calls when the error was raised, innermost
+	// first. The last frame is the top-level script.
+	Trace []Frame
+
+	// Cause is the Go error behind the failure, if any: an error returned by
+	// a native function, or the error of a cancelled context.
+	Cause error
+
+	// Thrown is set when a throw statement raised the error, rather than
+	// the interpreter itself; Value is then the thrown value, which may be
+	// nil.
+	Thrown bool
+	Value  any
+}
+
+// Frame is one entry of a Lox stack trace: the function that was running and
+// the position it had reached.
+type Frame struct {
+	Function string
+	token.Position
+}
+
+func (f Frame) String() string {
+	loc := f.File
+	if f.Line > 0 {
+		if loc != "" {
+			loc += ":"
+		}
+		loc += fmt.Sprintf("%d", f.Line)
+	}
+	return fmt.Sprintf("at %s (%s)", f.Function, loc)
+}
+
+func NewRuntimeError(tok *token.Token, msg string) *RuntimeError {
+	return &RuntimeError{
+		Position: tok.Pos(),
+		End:      tok.End(),
+		Msg:      msg,
+	}
+}
+
+func (e *RuntimeError) Span() token.Span {
+	return token.Span{Start: e.Position, End: e.End}
+}
+
+func (e *RuntimeError) Error() string {
+	if pos := e.Position.String(); pos != "" {
+		return fmt.Sprintf("%s: Runtime error: %s", pos, e.Msg)
+	}
+	return "Runtime error: " + e.Msg
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Cause
+}
+
+// tracebackEdge is how many frames Traceback keeps at each end of a long
+// trace, such as the one of a stack overflow.
+const tracebackEdge = 10
+
+// Traceback renders Trace one frame per line, innermost first. The middle
+// of a long trace is elided.
+func (e *RuntimeError) Traceback() string {
+	var lines []string
+	for i, f := range e.Trace {
+		if n := len(e.Trace); n > 2*tracebackEdge+1 && i >= tracebackEdge && i < n-tracebackEdge {
+			if i == tracebackEdge {
+				lines = append(lines, fmt.Sprintf("  ... %d more frames", n-2*tracebackEdge))
+			}
+			continue
+		}
+		lines = append(lines, "  "+f.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// List collects every error reported by one stage.
+type List []error
+
+func (l List) Error() string {
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (l List) Unwrap() []error {
+	return l
+}
+
+// Err returns nil for an empty list, so callers can return it as an error
+// without wrapping a nil slice in a non-nil interface.
+func (l List) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+func where(tok *token.Token) string {
+	if tok.Type() == token.EOF {
+		return " at end"
+	}
+	return " at '" + tok.Lexeme() + "'"
+}
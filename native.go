@@ -0,0 +1,29 @@
+package lox
+
+import "lox/interpreter"
+
+// Variadic is the arity of a native function accepting any number of
+// arguments.
+const Variadic = interpreter.Variadic
+
+// Func implements a native function in Go. See VM.RegisterFunc.
+type Func = interpreter.NativeFunc
+
+// The Arg helpers read one argument of a native function, returning an error
+// such as "Argument 2 must be a number." when it has the wrong type.
+
+func ArgNumber(args []any, i int) (float64, error) {
+	return interpreter.ArgNumber(args, i)
+}
+
+func ArgInt(args []any, i int) (int, error) {
+	return interpreter.ArgInt(args, i)
+}
+
+func ArgString(args []any, i int) (string, error) {
+	return interpreter.ArgString(args, i)
+}
+
+func ArgBool(args []any, i int) (bool, error) {
+	return interpreter.ArgBool(args, i)
+}
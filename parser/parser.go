@@ -1,45 +1,97 @@
 package parser
 
 import (
-	"fmt"
 	"lox/ast"
-
+	"lox/loxerr"
 	"lox/token"
 )
 
 type Parser struct {
 	tokens  []*token.Token
 	current int
+	errs    loxerr.List
 }
 
+// maxArgs is the most arguments a call, or parameters a function, can have.
+const maxArgs = 255
+
 func New(tokens []*token.Token) *Parser {
 	return &Parser{
 		tokens: tokens,
 	}
 }
 
-func (p *Parser) Parser() ast.Expr {
-	return p.expression()
+// Parser parses a single expression, which must make up all the tokens.
+func (p *Parser) Parser() (expr ast.Expr, err error) {
+	defer p.recoverError(&err)
+
+	expr = p.expression()
+	if !p.isAtEnd() {
+		panic(p.error(p.peek(), "Expect end of expression."))
+	}
+	return expr, nil
 }
 
-func (p *Parser) ParserStmt() []ast.Stmt {
+// ParserStmt parses a whole program. A syntax error does not stop parsing:
+// the parser skips to the next statement and carries on, so the returned
+// error lists every syntax error along with the statements that did parse.
+func (p *Parser) ParserStmt() ([]ast.Stmt, error) {
 	stmts := []ast.Stmt{}
 	for !p.isAtEnd() {
-		stmts = append(stmts, p.declaration())
+		if stmt := p.declaration(); stmt != nil {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts, p.errs.Err()
+}
+
+// recoverError stops a panic raised by p.error and stores it in err.
+func (p *Parser) recoverError(err *error) {
+	if r := recover(); r != nil {
+		parseErr, ok := r.(*loxerr.ParseError)
+		if !ok {
+			panic(r)
+		}
+		*err = parseErr
 	}
-	return stmts
 }
 
-func (p *Parser) declaration() ast.Stmt {
+// declaration parses one declaration, or returns nil after recording the
+// syntax error in it and skipping to the next statement boundary.
+func (p *Parser) declaration() (stmt ast.Stmt) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(*loxerr.ParseError)
+			if !ok {
+				panic(r)
+			}
+			p.report(err)
+			p.synchronize()
+			stmt = nil
+		}
+	}()
+
 	if p.match(token.CLASS) {
 		return p.classDeclaration()
 	}
-	if p.match(token.FUN) {
-		return p.function("function")
+	// "fun" followed by a name declares a function. Otherwise it starts an
+	// anonymous function expression, parsed as an expression statement.
+	if p.check(token.FUN) && p.checkAhead(1, token.IDENTIFIER) {
+		keyword := p.advance()
+		fn := p.function("function")
+		fn.SetSpan(keyword.Span().Join(fn.Span()))
+		return fn
 	}
 	if p.match(token.VAR) {
 		return p.varDeclaration()
 	}
+	if p.match(token.IMPORT) {
+		return p.importDeclaration()
+	}
+	// "from" is only a keyword when a module path follows it.
+	if p.checkWordAhead(0, "from") && p.checkAhead(1, token.STRING) {
+		return p.fromImportDeclaration()
+	}
 
 	return p.stmt()
 }
@@ -60,10 +112,31 @@ func (p *Parser) stmt() ast.Stmt {
 	if p.match(token.WHILE) {
 		return p.whileStmt()
 	}
+	if p.match(token.THROW) {
+		keyword := p.previous()
+		value := p.expression()
+		p.consume(token.SEMICOLON, "Expect ';' after thrown value.")
+		return at(&ast.ThrowStmt{Keyword: keyword, Value: value}, p.from(keyword))
+	}
+	if p.match(token.TRY) {
+		return p.tryStmt()
+	}
+	if p.match(token.BREAK) {
+		keyword := p.previous()
+		p.consume(token.SEMICOLON, "Expect ';' after 'break'.")
+		return at(&ast.BreakStmt{Keyword: keyword}, p.from(keyword))
+	}
+	if p.match(token.CONTINUE) {
+		keyword := p.previous()
+		p.consume(token.SEMICOLON, "Expect ';' after 'continue'.")
+		return at(&ast.ContinueStmt{Keyword: keyword}, p.from(keyword))
+	}
 	if p.match(token.LEFT_BRACE) {
-		return &ast.BlockStmt{
-			Statements: p.block(),
-		}
+		brace := p.previous()
+		statements := p.block()
+		return at(&ast.BlockStmt{
+			Statements: statements,
+		}, p.from(brace))
 	}
 
 	return p.expressionStmt()
@@ -78,40 +151,54 @@ func (p *Parser) returnStmt() ast.Stmt {
 
 	p.consume(token.SEMICOLON, "Expect ';' after return value.")
 
-	return &ast.ReturnStmt{
+	return at(&ast.ReturnStmt{
 		KeyWord: keyword,
 		Value:   value,
-	}
+	}, p.from(keyword))
 }
 
 func (p *Parser) function(kind string) *ast.FunctionStmt {
 	funcName := p.consume(token.IDENTIFIER, "Expect "+kind+" name.")
 
 	p.consume(token.LEFT_PAREN, "Expect '(' after "+kind+" name.")
+	parameters, body := p.functionBody(kind)
+
+	return at(&ast.FunctionStmt{
+		Name:   funcName,
+		Params: parameters,
+		Body:   body,
+	}, p.from(funcName))
+}
 
+// functionBody parses the parameters and body of a function, after its
+// opening parenthesis.
+func (p *Parser) functionBody(kind string) ([]*token.Token, []ast.Stmt) {
 	parameters := []*token.Token{}
 	if !p.check(token.RIGHT_PAREN) {
 		parameters = append(parameters, p.consume(token.IDENTIFIER, "Expect parameter name."))
-	}
-	for p.match(token.COMMA) {
-		parameters = append(parameters, p.consume(token.IDENTIFIER, "Expect parameter name."))
+		for p.match(token.COMMA) {
+			if len(parameters) >= maxArgs {
+				p.report(p.error(p.peek(), "Can't have more than 255 parameters."))
+			}
+			parameters = append(parameters, p.consume(token.IDENTIFIER, "Expect parameter name."))
+		}
 	}
 
 	p.consume(token.RIGHT_PAREN, "Expect ')' after parameters.")
 	p.consume(token.LEFT_BRACE, "Expect '{' before "+kind+" body.")
 
-	body := p.block()
-
-	return &ast.FunctionStmt{
-		Name:   funcName,
-		Params: parameters,
-		Body:   body,
-	}
+	return parameters, p.block()
 }
 
 func (p *Parser) forStmt() ast.Stmt {
+	keyword := p.previous()
 	p.consume(token.LEFT_PAREN, "Expect '(' after 'for'.")
 
+	// "in" is only a keyword after the variable of a for-in loop.
+	if p.check(token.VAR) && p.checkAhead(1, token.IDENTIFIER) && p.checkWordAhead(2, "in") {
+		return p.forInStmt(keyword)
+	}
+
 	var initStmt ast.Stmt
 	if p.match(token.SEMICOLON) {
 		initStmt = nil
@@ -137,52 +224,90 @@ func (p *Parser) forStmt() ast.Stmt {
 
 	body := p.stmt()
 
-	if increment != nil {
-		body = &ast.BlockStmt{
-			Statements: []ast.Stmt{
-				body,
-				&ast.ExpressionStmt{
-					Expression: increment,
-				}},
-		}
-	}
-
+	// The desugared nodes all cover the whole for statement. The increment
+	// stays apart from the body so that continue still runs it.
+	span := p.from(keyword)
 	if condition == nil {
-		condition = &ast.LiteralExpr{
+		condition = at(&ast.LiteralExpr{
 			Val: true,
-		}
+		}, keyword.Span())
 	}
 
-	body = &ast.WhileStmt{
+	body = at(&ast.WhileStmt{
 		Condition: condition,
 		Body:      body,
-	}
+		Increment: increment,
+	}, span)
 
 	if initStmt != nil {
-		body = &ast.BlockStmt{
+		body = at(&ast.BlockStmt{
 			Statements: []ast.Stmt{
 				initStmt,
 				body,
 			},
-		}
+		}, span)
 	}
 
 	return body
 }
 
+// forInStmt parses the rest of "for (var name in iterable) body", after
+// the opening parenthesis.
+func (p *Parser) forInStmt(keyword *token.Token) ast.Stmt {
+	p.advance()
+	name := p.advance()
+	p.advance()
+	iterable := p.expression()
+	p.consume(token.RIGHT_PAREN, "Expect ')' after for-in clause.")
+	body := p.stmt()
+
+	return at(&ast.ForInStmt{
+		Keyword:  keyword,
+		Name:     name,
+		Iterable: iterable,
+		Body:     body,
+	}, p.from(keyword))
+}
+
+func (p *Parser) tryStmt() ast.Stmt {
+	stmt := &ast.TryStmt{Keyword: p.previous()}
+	p.consume(token.LEFT_BRACE, "Expect '{' after 'try'.")
+	stmt.Body = p.block()
+
+	if p.match(token.CATCH) {
+		p.consume(token.LEFT_PAREN, "Expect '(' after 'catch'.")
+		stmt.CatchName = p.consume(token.IDENTIFIER, "Expect exception variable name.")
+		p.consume(token.RIGHT_PAREN, "Expect ')' after exception variable.")
+		p.consume(token.LEFT_BRACE, "Expect '{' after catch clause.")
+		stmt.Catch = p.block()
+	}
+	if p.match(token.FINALLY) {
+		p.consume(token.LEFT_BRACE, "Expect '{' after 'finally'.")
+		stmt.Finally = p.block()
+		stmt.HasFinally = true
+	}
+	if stmt.CatchName == nil && !stmt.HasFinally {
+		panic(p.error(p.peek(), "Expect 'catch' or 'finally' after try block."))
+	}
+
+	return at(stmt, p.from(stmt.Keyword))
+}
+
 func (p *Parser) whileStmt() ast.Stmt {
+	keyword := p.previous()
 	p.consume(token.LEFT_PAREN, "Expect '(' after 'while'.")
 	condition := p.expression()
 	p.consume(token.RIGHT_PAREN, "Expect ')' after condition.")
 	body := p.stmt()
 
-	return &ast.WhileStmt{
+	return at(&ast.WhileStmt{
 		Condition: condition,
 		Body:      body,
-	}
+	}, p.from(keyword))
 }
 
 func (p *Parser) ifStmt() ast.Stmt {
+	keyword := p.previous()
 	p.consume(token.LEFT_PAREN, "Expect '(' after 'if'.")
 	condition := p.expression()
 	p.consume(token.RIGHT_PAREN, "Expect ')' after if condition.")
@@ -193,22 +318,23 @@ func (p *Parser) ifStmt() ast.Stmt {
 		elseBranch = p.stmt()
 	}
 
-	return &ast.IfStmt{
+	return at(&ast.IfStmt{
 		Condition: condition,
 		Then:      thenBranch,
 		Else:      elseBranch,
-	}
+	}, p.from(keyword))
 }
 
 func (p *Parser) classDeclaration() ast.Stmt {
+	keyword := p.previous()
 	name := p.consume(token.IDENTIFIER, "Expect class name.")
 
 	var superClass *ast.VariableExpr
 	if p.match(token.LESS) {
-		p.consume(token.IDENTIFIER, "Expect super class name.")
-		superClass = &ast.VariableExpr{
+		p.consume(token.IDENTIFIER, "Expect superclass name.")
+		superClass = at(&ast.VariableExpr{
 			Name: p.previous(),
-		}
+		}, p.previous().Span())
 	}
 
 	p.consume(token.LEFT_BRACE, "Expect '{' before class body.")
@@ -218,17 +344,55 @@ func (p *Parser) classDeclaration() ast.Stmt {
 		methods = append(methods, p.function("method"))
 	}
 
-	p.consume(token.RIGHT_BRACE, "Epect '}' after class body.")
+	p.consume(token.RIGHT_BRACE, "Expect '}' after class body.")
 
-	return &ast.ClassStmt{
+	return at(&ast.ClassStmt{
 		Name:       name,
 		SuperClass: superClass,
 		Methods:    methods,
+	}, p.from(keyword))
+}
+
+// importDeclaration parses import "path" as alias;
+func (p *Parser) importDeclaration() ast.Stmt {
+	keyword := p.previous()
+	path := p.consume(token.STRING, "Expect module path after 'import'.")
+	if !p.checkWordAhead(0, "as") {
+		panic(p.error(p.peek(), "Expect 'as' after module path."))
 	}
+	p.advance()
+	alias := p.consume(token.IDENTIFIER, "Expect module name after 'as'.")
+	p.consume(token.SEMICOLON, "Expect ';' after import.")
+
+	return at(&ast.ImportStmt{
+		Keyword: keyword,
+		Path:    path,
+		Alias:   alias,
+	}, p.from(keyword))
+}
+
+// fromImportDeclaration parses from "path" import name, ...;
+func (p *Parser) fromImportDeclaration() ast.Stmt {
+	keyword := p.advance()
+	path := p.advance()
+	p.consume(token.IMPORT, "Expect 'import' after module path.")
+
+	names := []*token.Token{p.consume(token.IDENTIFIER, "Expect name to import.")}
+	for p.match(token.COMMA) {
+		names = append(names, p.consume(token.IDENTIFIER, "Expect name to import."))
+	}
+	p.consume(token.SEMICOLON, "Expect ';' after import.")
+
+	return at(&ast.ImportStmt{
+		Keyword: keyword,
+		Path:    path,
+		Names:   names,
+	}, p.from(keyword))
 }
 
 func (p *Parser) varDeclaration() ast.Stmt {
-	name := p.consume(token.IDENTIFIER, "Expect variable name")
+	keyword := p.previous()
+	name := p.consume(token.IDENTIFIER, "Expect variable name.")
 	var initializer ast.Expr
 	if p.match(token.EQUAL) {
 		initializer = p.expression()
@@ -236,40 +400,43 @@ func (p *Parser) varDeclaration() ast.Stmt {
 
 	p.consume(token.SEMICOLON, "Expect ';' after variable declaration.")
 
-	return &ast.VarStmt{
+	return at(&ast.VarStmt{
 		Name:        name,
 		Initializer: initializer,
-	}
+	}, p.from(keyword))
 }
 
 func (p *Parser) block() []ast.Stmt {
 	var statements []ast.Stmt
 
 	for !p.check(token.RIGHT_BRACE) && !p.isAtEnd() {
-		statements = append(statements, p.declaration())
+		if stmt := p.declaration(); stmt != nil {
+			statements = append(statements, stmt)
+		}
 	}
 
-	p.consume(token.RIGHT_BRACE, "Expect '}' after block")
+	p.consume(token.RIGHT_BRACE, "Expect '}' after block.")
 
 	return statements
 }
 
 func (p *Parser) printStmt() ast.Stmt {
+	keyword := p.previous()
 	expr := p.expression()
 	p.consume(token.SEMICOLON, "Expect ';' after value.")
 
-	return &ast.PrintStmt{
+	return at(&ast.PrintStmt{
 		Expression: expr,
-	}
+	}, p.from(keyword))
 }
 
 func (p *Parser) expressionStmt() ast.Stmt {
 	expr := p.expression()
-	p.consume(token.SEMICOLON, "Expect ';' after value.")
+	p.consume(token.SEMICOLON, "Expect ';' after expression.")
 
-	return &ast.ExpressionStmt{
+	return at(&ast.ExpressionStmt{
 		Expression: expr,
-	}
+	}, expr.Span().Join(p.previous().Span()))
 }
 
 func (p *Parser) synchronize() {
@@ -281,7 +448,8 @@ func (p *Parser) synchronize() {
 		}
 
 		switch p.peek().Type() {
-		case token.CLASS, token.FUN, token.VAR, token.FOR, token.IF, token.WHILE, token.PRINT, token.RETURN:
+		case token.CLASS, token.FUN, token.VAR, token.FOR, token.IF, token.WHILE, token.PRINT, token.RETURN,
+			token.BREAK, token.CONTINUE, token.THROW, token.TRY, token.IMPORT:
 			return
 		}
 
@@ -297,19 +465,28 @@ func (p *Parser) assignment() ast.Expr {
 
 		switch v := expr.(type) {
 		case *ast.VariableExpr:
-			return &ast.AssignExpr{
+			return at(&ast.AssignExpr{
 				Name:  v.Name,
 				Value: val,
-			}
+			}, expr.Span().Join(val.Span()))
 		case *ast.GetExpr:
-			return &ast.SetExpr{
+			return at(&ast.SetExpr{
 				Object: v.Object,
 				Name:   v.Name,
 				Value:  val,
-			}
+			}, expr.Span().Join(val.Span()))
+		case *ast.IndexExpr:
+			return at(&ast.IndexSetExpr{
+				Object:  v.Object,
+				Bracket: v.Bracket,
+				Index:   v.Index,
+				Value:   val,
+			}, expr.Span().Join(val.Span()))
 		}
 
-		panic(fmt.Errorf("Invalid assignment target: %s", equals.Literal()))
+		// The parser is not in a confused state, so report without
+		// synchronizing.
+		p.report(p.error(equals, "Invalid assignment target."))
 	}
 
 	return expr
@@ -321,11 +498,11 @@ func (p *Parser) or() ast.Expr {
 	for p.match(token.OR) {
 		op := p.previous()
 		right := p.and()
-		expr = &ast.LogicalExpr{
+		expr = at(&ast.LogicalExpr{
 			Left:     expr,
 			Operator: op,
 			Right:    right,
-		}
+		}, expr.Span().Join(right.Span()))
 	}
 
 	return expr
@@ -337,11 +514,11 @@ func (p *Parser) and() ast.Expr {
 	for p.match(token.AND) {
 		op := p.previous()
 		right := p.equality()
-		expr = &ast.LogicalExpr{
+		expr = at(&ast.LogicalExpr{
 			Left:     expr,
 			Operator: op,
 			Right:    right,
-		}
+		}, expr.Span().Join(right.Span()))
 	}
 
 	return expr
@@ -365,11 +542,11 @@ func (p *Parser) equality() ast.Expr {
 	for p.match(token.BANG_EQUAL, token.EQUAL_EQUAL) {
 		op := p.previous()
 		right := p.comparision()
-		expr = &ast.BinaryExpr{
+		expr = at(&ast.BinaryExpr{
 			Left:  expr,
 			Op:    *op,
 			Right: right,
-		}
+		}, expr.Span().Join(right.Span()))
 	}
 
 	return expr
@@ -383,11 +560,11 @@ func (p *Parser) comparision() ast.Expr {
 	for p.match(token.GREATER, token.GREATER_EQUAL, token.LESS, token.LESS_EQUAL) {
 		op := p.previous()
 		right := p.term()
-		expr = &ast.BinaryExpr{
+		expr = at(&ast.BinaryExpr{
 			Left:  expr,
 			Op:    *op,
 			Right: right,
-		}
+		}, expr.Span().Join(right.Span()))
 	}
 
 	return expr
@@ -399,11 +576,11 @@ func (p *Parser) term() ast.Expr {
 	for p.match(token.MINUS, token.PLUS) {
 		op := p.previous()
 		right := p.factor()
-		expr = &ast.BinaryExpr{
+		expr = at(&ast.BinaryExpr{
 			Left:  expr,
 			Op:    *op,
 			Right: right,
-		}
+		}, expr.Span().Join(right.Span()))
 	}
 
 	return expr
@@ -414,11 +591,11 @@ func (p *Parser) factor() ast.Expr {
 	for p.match(token.SLASH, token.STAR) {
 		op := p.previous()
 		right := p.unary()
-		expr = &ast.BinaryExpr{
+		expr = at(&ast.BinaryExpr{
 			Left:  expr,
 			Op:    *op,
 			Right: right,
-		}
+		}, expr.Span().Join(right.Span()))
 	}
 	return expr
 }
@@ -430,10 +607,10 @@ func (p *Parser) unary() ast.Expr {
 	if p.match(token.BANG, token.MINUS) {
 		op := p.previous()
 		right := p.unary()
-		return &ast.UnaryExpr{
+		return at(&ast.UnaryExpr{
 			Op:    *op,
 			Right: right,
-		}
+		}, op.Span().Join(right.Span()))
 	}
 
 	return p.call()
@@ -447,10 +624,18 @@ func (p *Parser) call() ast.Expr {
 			expr = p.finishCall(expr)
 		} else if p.match(token.DOT) {
 			name := p.consume(token.IDENTIFIER, "Expect property name after '.'.")
-			expr = &ast.GetExpr{
+			expr = at(&ast.GetExpr{
 				Name:   name,
 				Object: expr,
-			}
+			}, expr.Span().Join(name.Span()))
+		} else if p.match(token.LEFT_BRACKET) {
+			index := p.expression()
+			bracket := p.consume(token.RIGHT_BRACKET, "Expect ']' after index.")
+			expr = at(&ast.IndexExpr{
+				Object:  expr,
+				Bracket: bracket,
+				Index:   index,
+			}, expr.Span().Join(bracket.Span()))
 		} else {
 			break
 		}
@@ -464,63 +649,121 @@ func (p *Parser) finishCall(callee ast.Expr) ast.Expr {
 	if !p.check(token.RIGHT_PAREN) {
 		args = append(args, p.expression())
 		for p.match(token.COMMA) {
+			if len(args) >= maxArgs {
+				p.report(p.error(p.peek(), "Can't have more than 255 arguments."))
+			}
 			args = append(args, p.expression())
 		}
 	}
 
-	paren := p.consume(token.RIGHT_PAREN, "Expect ')' after arguments")
+	paren := p.consume(token.RIGHT_PAREN, "Expect ')' after arguments.")
 
-	return &ast.CallExpr{
+	return at(&ast.CallExpr{
 		Callee:    callee,
 		Paren:     paren,
 		Arguments: args,
-	}
+	}, callee.Span().Join(paren.Span()))
 }
 
 // primary        → NUMBER | STRING | "true" | "false" | "nil"
 //
-//	| "(" expression ")" ;
+//	| "(" expression ")" | "[" arguments? "]"
+//	| "{" ( expression ":" expression ( "," expression ":" expression )* )? "}"
+//	| "fun" "(" parameters? ")" block ;
 func (p *Parser) primary() ast.Expr {
 	if p.match(token.FALSE) {
-		return &ast.LiteralExpr{Val: false}
+		return at(&ast.LiteralExpr{Val: false}, p.previous().Span())
 	}
 	if p.match(token.TRUE) {
-		return &ast.LiteralExpr{Val: true}
+		return at(&ast.LiteralExpr{Val: true}, p.previous().Span())
 	}
 	if p.match(token.NIL) {
-		return &ast.LiteralExpr{Val: nil}
+		return at(&ast.LiteralExpr{Val: nil}, p.previous().Span())
 	}
 	if p.match(token.NUMBER, token.STRING) {
-		return &ast.LiteralExpr{Val: p.previous().Literal()}
+		return at(&ast.LiteralExpr{Val: p.previous().Literal()}, p.previous().Span())
 	}
 	if p.match(token.SUPER) {
 		k := p.previous()
 		p.consume(token.DOT, "Expect '.' after 'super'.")
 		m := p.consume(token.IDENTIFIER, "Expect superclass method name.")
-		return &ast.SuperExpr{
+		return at(&ast.SuperExpr{
 			Keyword: k,
 			Method:  m,
-		}
+		}, p.from(k))
 	}
 	if p.match(token.THIS) {
-		return &ast.ThisExpr{
+		return at(&ast.ThisExpr{
 			Keyword: p.previous(),
-		}
+		}, p.previous().Span())
 	}
 	if p.match(token.IDENTIFIER) {
-		return &ast.VariableExpr{
+		return at(&ast.VariableExpr{
 			Name: p.previous(),
+		}, p.previous().Span())
+	}
+	if p.match(token.LEFT_BRACKET) {
+		bracket := p.previous()
+		elements := []ast.Expr{}
+		if !p.check(token.RIGHT_BRACKET) {
+			elements = append(elements, p.expression())
+			for p.match(token.COMMA) {
+				elements = append(elements, p.expression())
+			}
 		}
+		p.consume(token.RIGHT_BRACKET, "Expect ']' after list elements.")
+		return at(&ast.ListExpr{
+			Elements: elements,
+		}, p.from(bracket))
+	}
+	if p.match(token.LEFT_BRACE) {
+		return p.mapLiteral()
+	}
+	if p.match(token.FUN) {
+		keyword := p.previous()
+		p.consume(token.LEFT_PAREN, "Expect '(' after 'fun'.")
+		parameters, body := p.functionBody("function")
+		return at(&ast.FunctionExpr{
+			Keyword: keyword,
+			Params:  parameters,
+			Body:    body,
+		}, p.from(keyword))
 	}
 	if p.match(token.LEFT_PAREN) {
+		paren := p.previous()
 		expr := p.expression()
 		p.consume(token.RIGHT_PAREN, "Expect ')' after expression.")
-		return &ast.GroupingExpr{
+		return at(&ast.GroupingExpr{
 			Expression: expr,
+		}, p.from(paren))
+	}
+
+	panic(p.error(p.peek(), "Expect expression."))
+}
+
+// mapLiteral parses the entries of a map literal, after its opening brace.
+// A brace starting a statement is a block instead.
+func (p *Parser) mapLiteral() ast.Expr {
+	brace := p.previous()
+	keys := []ast.Expr{}
+	values := []ast.Expr{}
+	if !p.check(token.RIGHT_BRACE) {
+		for {
+			keys = append(keys, p.expression())
+			p.consume(token.COLON, "Expect ':' after map key.")
+			values = append(values, p.expression())
+			if !p.match(token.COMMA) {
+				break
+			}
 		}
 	}
+	p.consume(token.RIGHT_BRACE, "Expect '}' after map entries.")
 
-	panic("Expect expression.")
+	return at(&ast.MapExpr{
+		Brace:  brace,
+		Keys:   keys,
+		Values: values,
+	}, p.from(brace))
 }
 
 func (p *Parser) consume(t token.Type, msg string) *token.Token {
@@ -528,8 +771,27 @@ func (p *Parser) consume(t token.Type, msg string) *token.Token {
 		return p.advance()
 	}
 
-	err := fmt.Errorf("consume TokenType: %s error, msg: %s", t, msg)
-	panic(err)
+	panic(p.error(p.peek(), msg))
+}
+
+// at records span as the source range of node.
+func at[T interface{ SetSpan(token.Span) }](node T, span token.Span) T {
+	node.SetSpan(span)
+	return node
+}
+
+// from returns the span from start to the last consumed token.
+func (p *Parser) from(start *token.Token) token.Span {
+	return start.Span().Join(p.previous().Span())
+}
+
+func (p *Parser) error(tok *token.Token, msg string) *loxerr.ParseError {
+	return loxerr.NewParseError(tok, msg)
+}
+
+// report records err and lets parsing continue from the current token.
+func (p *Parser) report(err *loxerr.ParseError) {
+	p.errs = append(p.errs, err)
 }
 
 func (p *Parser) match(types ...token.Type) bool {
@@ -551,6 +813,22 @@ func (p *Parser) check(t token.Type) bool {
 	return p.peek().Type() == t
 }
 
+// checkAhead reports whether the token n places after the current one has
+// type t.
+func (p *Parser) checkAhead(n int, t token.Type) bool {
+	if p.current+n >= len(p.tokens) {
+		return false
+	}
+
+	return p.tokens[p.current+n].Type() == t
+}
+
+// checkWordAhead reports whether the token n places after the current one
+// is the identifier word.
+func (p *Parser) checkWordAhead(n int, word string) bool {
+	return p.checkAhead(n, token.IDENTIFIER) && p.tokens[p.current+n].Lexeme() == word
+}
+
 func (p *Parser) advance() *token.Token {
 	if !p.isAtEnd() {
 		p.current++
@@ -1,10 +1,9 @@
 package resolver
 
 import (
-	"fmt"
 	"lox/ast"
 	"lox/dst"
-	"lox/interpreter"
+	"lox/loxerr"
 	"lox/token"
 )
 
@@ -13,25 +12,48 @@ var (
 	_ ast.StmtVisitor = (*Resolver)(nil)
 )
 
+// Locals receives the resolution of each reference to a local variable:
+// how many scopes out from the reference the variable is declared, and its
+// slot in that scope. Any other variable is global. The interpreter and the
+// bytecode VM both implement it.
+type Locals interface {
+	Resolve(expr ast.Expr, depth int, slot int)
+}
+
 type Resolver struct {
-	interpreter *interpreter.Interpreter
-	scopes      *dst.Stack[map[string]bool]
+	locals Locals
+	scopes *dst.Stack[scope]
+	errs   loxerr.List
 
 	currentFunc  FunctionType
 	currentClass ClassType
+	loopDepth    int // loops enclosing the current statement, within its function
+	funcDepth    int // functions enclosing the current statement
 }
 
-func NewResolver(i *interpreter.Interpreter) *Resolver {
+func NewResolver(locals Locals) *Resolver {
 	return &Resolver{
-		interpreter:  i,
-		scopes:       dst.NewStack[map[string]bool](),
+		locals:       locals,
+		scopes:       dst.NewStack[scope](),
 		currentFunc:  FT_NONE,
 		currentClass: CT_NONE,
 	}
 }
 
+// scope maps the names declared in a local scope to their variables.
+type scope map[string]variable
+
+// variable is a local variable. Slots number the variables of a scope in
+// the order they are declared, which is the order the interpreter defines
+// them in.
+type variable struct {
+	slot      int
+	defined   bool // false while resolving its initializer
+	funcDepth int  // of the function declaring it
+}
+
 func (r *Resolver) beginScope() {
-	r.scopes.Push(map[string]bool{})
+	r.scopes.Push(scope{})
 }
 
 func (r *Resolver) endScope() {
@@ -44,17 +66,22 @@ func (r *Resolver) resolveListStmt(stmts []ast.Stmt) {
 	}
 }
 
-func (r *Resolver) resolveFunction(f *ast.FunctionStmt, funcType FunctionType) {
+func (r *Resolver) resolveFunction(params []*token.Token, body []ast.Stmt, funcType FunctionType) {
 	r.beginScope()
 	enclosingFunc := r.currentFunc
+	enclosingLoopDepth := r.loopDepth
 	r.currentFunc = funcType
-	for _, param := range f.Params {
+	r.loopDepth = 0
+	r.funcDepth++
+	for _, param := range params {
 		r.declare(param)
 		r.define(param)
 	}
-	r.resolveListStmt(f.Body)
+	r.resolveListStmt(body)
 	r.endScope()
 	r.currentFunc = enclosingFunc
+	r.loopDepth = enclosingLoopDepth
+	r.funcDepth--
 }
 
 func (r *Resolver) resolveStmt(stmt ast.Stmt) {
@@ -65,12 +92,15 @@ func (r *Resolver) resolveExpr(expr ast.Expr) {
 	expr.Accept(r)
 }
 
+// resolveLocal resolves a reference to the innermost local called name. A
+// function in the initializer of a local can refer to it: the local holds
+// nil until its initializer is done.
 func (r *Resolver) resolveLocal(expr ast.Expr, name *token.Token) {
 	pointer := r.scopes.Peek()
 	dept := 0
 	for pointer != nil {
-		if pointer.Val[name.Lexeme()] {
-			r.interpreter.Resolve(expr, dept)
+		if v, has := pointer.Val[name.Lexeme()]; has && (v.defined || v.funcDepth < r.funcDepth) {
+			r.locals.Resolve(expr, dept, v.slot)
 			return
 		}
 		pointer = pointer.Next
@@ -85,9 +115,10 @@ func (r *Resolver) declare(name *token.Token) {
 
 	scope := r.scopes.Peek().Val
 	if _, has := scope[name.Lexeme()]; has {
-		panic(fmt.Errorf("%s Already a variable with this name in this scope.", name.String()))
+		r.error(name, "Already a variable with this name in this scope.")
+		return
 	}
-	scope[name.Lexeme()] = false
+	scope[name.Lexeme()] = variable{slot: len(scope), funcDepth: r.funcDepth}
 }
 
 func (r *Resolver) define(name *token.Token) {
@@ -96,11 +127,21 @@ func (r *Resolver) define(name *token.Token) {
 	}
 
 	scope := r.scopes.Peek().Val
-	scope[name.Lexeme()] = true
+	v := scope[name.Lexeme()]
+	v.defined = true
+	scope[name.Lexeme()] = v
 }
 
-func (r *Resolver) Resolve(stmts []ast.Stmt) {
+// Resolve resolves every local variable in stmts. All static errors are
+// reported at once; the program must not be run when the result is non-nil.
+func (r *Resolver) Resolve(stmts []ast.Stmt) error {
+	r.errs = nil
 	r.resolveListStmt(stmts)
+	return r.errs.Err()
+}
+
+func (r *Resolver) error(tok *token.Token, msg string) {
+	r.errs = append(r.errs, loxerr.NewResolveError(tok, msg))
 }
 
 func (r *Resolver) VisitBlockStmt(stmt *ast.BlockStmt) any {
@@ -119,7 +160,7 @@ func (r *Resolver) VisitFunctionStmt(stmt *ast.FunctionStmt) any {
 	r.declare(stmt.Name)
 	r.define(stmt.Name)
 
-	r.resolveFunction(stmt, FT_FUNCTION)
+	r.resolveFunction(stmt.Params, stmt.Body, FT_FUNCTION)
 
 	return nil
 }
@@ -140,12 +181,12 @@ func (r *Resolver) VisitPrintStmt(stmt *ast.PrintStmt) any {
 
 func (r *Resolver) VisitReturnStmt(stmt *ast.ReturnStmt) any {
 	if r.currentFunc == FT_NONE {
-		panic(fmt.Errorf("%s Can't return from top-level code.", stmt.KeyWord.String()))
+		r.error(stmt.KeyWord, "Can't return from top-level code.")
 	}
 
 	if stmt.Value != nil {
 		if r.currentFunc == FT_INITIALIZER {
-			panic(fmt.Errorf("%v Can't return a value from an initializer.", stmt.KeyWord))
+			r.error(stmt.KeyWord, "Can't return a value from an initializer.")
 		}
 
 		r.resolveExpr(stmt.Value)
@@ -165,7 +206,78 @@ func (r *Resolver) VisitVarStmt(stmt *ast.VarStmt) any {
 
 func (r *Resolver) VisitWhileStmt(stmt *ast.WhileStmt) any {
 	r.resolveExpr(stmt.Condition)
+	r.loopDepth++
 	r.resolveStmt(stmt.Body)
+	r.loopDepth--
+	if stmt.Increment != nil {
+		r.resolveExpr(stmt.Increment)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitForInStmt(stmt *ast.ForInStmt) any {
+	r.resolveExpr(stmt.Iterable)
+
+	// The interpreter binds the loop variable in a fresh environment for
+	// every iteration, so closures in the body capture that iteration's
+	// value.
+	r.beginScope()
+	r.declare(stmt.Name)
+	r.define(stmt.Name)
+	r.loopDepth++
+	r.resolveStmt(stmt.Body)
+	r.loopDepth--
+	r.endScope()
+
+	return nil
+}
+
+func (r *Resolver) VisitThrowStmt(stmt *ast.ThrowStmt) any {
+	r.resolveExpr(stmt.Value)
+	return nil
+}
+
+func (r *Resolver) VisitTryStmt(stmt *ast.TryStmt) any {
+	r.beginScope()
+	r.resolveListStmt(stmt.Body)
+	r.endScope()
+
+	if stmt.CatchName != nil {
+		r.beginScope()
+		r.declare(stmt.CatchName)
+		r.define(stmt.CatchName)
+		r.resolveListStmt(stmt.Catch)
+		r.endScope()
+	}
+
+	if stmt.HasFinally {
+		r.beginScope()
+		r.resolveListStmt(stmt.Finally)
+		r.endScope()
+	}
+
+	return nil
+}
+
+func (r *Resolver) VisitImportStmt(stmt *ast.ImportStmt) any {
+	// Imported names are globals of the importing module.
+	if !r.scopes.IsEmpty() {
+		r.error(stmt.Keyword, "Can only import at the top level.")
+	}
+	return nil
+}
+
+func (r *Resolver) VisitBreakStmt(stmt *ast.BreakStmt) any {
+	if r.loopDepth == 0 {
+		r.error(stmt.Keyword, "Can't use 'break' outside of a loop.")
+	}
+	return nil
+}
+
+func (r *Resolver) VisitContinueStmt(stmt *ast.ContinueStmt) any {
+	if r.loopDepth == 0 {
+		r.error(stmt.Keyword, "Can't use 'continue' outside of a loop.")
+	}
 	return nil
 }
 
@@ -178,26 +290,24 @@ func (r *Resolver) VisitClassStmt(stmt *ast.ClassStmt) any {
 
 	if stmt.SuperClass != nil {
 		if stmt.Name.Lexeme() == stmt.SuperClass.Name.Lexeme() {
-			panic(fmt.Errorf("A class '%s' can't inherit from itself.", stmt.Name.Lexeme()))
+			r.error(stmt.SuperClass.Name, "A class can't inherit from itself.")
 		}
 
 		r.currentClass = CT_SUBCLASS
 		r.resolveExpr(stmt.SuperClass)
 
 		r.beginScope()
-		peek := r.scopes.Peek().Val
-		peek["super"] = true
+		r.scopes.Peek().Val["super"] = variable{defined: true}
 	}
 
 	r.beginScope()
-	peek := r.scopes.Peek().Val
-	peek["this"] = true
+	r.scopes.Peek().Val["this"] = variable{defined: true}
 	for _, method := range stmt.Methods {
 		declaration := FT_METHOD
 		if method.Name.Lexeme() == "init" {
 			declaration = FT_INITIALIZER
 		}
-		r.resolveFunction(method, declaration)
+		r.resolveFunction(method.Params, method.Body, declaration)
 	}
 	r.endScope()
 
@@ -254,8 +364,8 @@ func (r *Resolver) VisitUnaryExpr(expr *ast.UnaryExpr) any {
 func (r *Resolver) VisitVariableExpr(expr *ast.VariableExpr) any {
 	if !r.scopes.IsEmpty() {
 		scope := r.scopes.Peek().Val
-		if val, has := scope[expr.Name.Lexeme()]; has && !val {
-			panic(expr.Name.String() + "Can't read local variable in its own initializer.")
+		if v, has := scope[expr.Name.Lexeme()]; has && !v.defined {
+			r.error(expr.Name, "Can't read local variable in its own initializer.")
 		}
 	}
 
@@ -277,18 +387,52 @@ func (r *Resolver) VisitSetExpr(expr *ast.SetExpr) any {
 
 func (r *Resolver) VisitThisExpr(expr *ast.ThisExpr) any {
 	if r.currentClass == CT_NONE {
-		panic(fmt.Errorf("%v Can't use 'this' outside of a class.", expr.Keyword))
+		r.error(expr.Keyword, "Can't use 'this' outside of a class.")
+		return nil
 	}
 
 	r.resolveLocal(expr, expr.Keyword)
 	return nil
 }
 
+func (r *Resolver) VisitFunctionExpr(expr *ast.FunctionExpr) any {
+	r.resolveFunction(expr.Params, expr.Body, FT_FUNCTION)
+	return nil
+}
+
+func (r *Resolver) VisitListExpr(expr *ast.ListExpr) any {
+	for _, element := range expr.Elements {
+		r.resolveExpr(element)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitMapExpr(expr *ast.MapExpr) any {
+	for n, key := range expr.Keys {
+		r.resolveExpr(key)
+		r.resolveExpr(expr.Values[n])
+	}
+	return nil
+}
+
+func (r *Resolver) VisitIndexExpr(expr *ast.IndexExpr) any {
+	r.resolveExpr(expr.Object)
+	r.resolveExpr(expr.Index)
+	return nil
+}
+
+func (r *Resolver) VisitIndexSetExpr(expr *ast.IndexSetExpr) any {
+	r.resolveExpr(expr.Object)
+	r.resolveExpr(expr.Index)
+	r.resolveExpr(expr.Value)
+	return nil
+}
+
 func (r *Resolver) VisitSuperExpr(expr *ast.SuperExpr) any {
 	if r.currentClass == CT_NONE {
-		panic("Can't use 'super' outside of a class.")
+		r.error(expr.Keyword, "Can't use 'super' outside of a class.")
 	} else if r.currentClass != CT_SUBCLASS {
-		panic("Can't use 'super' in a class with no superclass.")
+		r.error(expr.Keyword, "Can't use 'super' in a class with no superclass.")
 	}
 
 	r.resolveLocal(expr, expr.Keyword)
@@ -1,23 +1,31 @@
 package scanner
 
 import (
-	"fmt"
+	"lox/loxerr"
 	"lox/token"
-	"os"
 	"strconv"
+	"unicode/utf8"
 )
 
 type Scanner struct {
+	file   string
 	source []rune
 	tokens []*token.Token
+	errs   loxerr.List
 
-	start   int
-	current int
-	line    int
+	start     int
+	current   int
+	offset    int // byte offset of current
+	line      int
+	lineStart int
+
+	// position of the token being scanned
+	startPos token.Position
 }
 
-func NewScanner(source []rune) *Scanner {
+func NewScanner(file string, source []rune) *Scanner {
 	return &Scanner{
+		file:   file,
 		source: source,
 		tokens: []*token.Token{},
 		line:   1,
@@ -31,6 +39,7 @@ func (s *Scanner) isAtEnd() bool {
 func (s *Scanner) advance() rune {
 	c := s.source[s.current]
 	s.current++
+	s.offset += utf8.RuneLen(c)
 	return c
 }
 
@@ -40,7 +49,7 @@ func (s *Scanner) addToken(t token.Type) {
 
 func (s *Scanner) addTokenLiteral(t token.Type, literal any) {
 	text := s.source[s.start:s.current]
-	token := token.New(t, string(text), literal, s.line)
+	token := token.New(t, string(text), literal, s.span())
 	s.tokens = append(s.tokens, token)
 }
 
@@ -55,6 +64,10 @@ func (s *Scanner) scanToken() {
 		s.addToken(token.LEFT_BRACE)
 	case '}':
 		s.addToken(token.RIGHT_BRACE)
+	case '[':
+		s.addToken(token.LEFT_BRACKET)
+	case ']':
+		s.addToken(token.RIGHT_BRACKET)
 	case ',':
 		s.addToken(token.COMMA)
 	case '.':
@@ -65,6 +78,8 @@ func (s *Scanner) scanToken() {
 		s.addToken(token.PLUS)
 	case ';':
 		s.addToken(token.SEMICOLON)
+	case ':':
+		s.addToken(token.COLON)
 	case '*':
 		s.addToken(token.STAR)
 	case '!':
@@ -104,7 +119,7 @@ func (s *Scanner) scanToken() {
 		// Ignore whitespace.
 		break
 	case '\n':
-		s.line++
+		s.newLine()
 	case '"':
 		s.string()
 	default:
@@ -113,7 +128,7 @@ func (s *Scanner) scanToken() {
 		} else if s.isAlpha(c) {
 			s.identifier()
 		} else {
-			s.error(s.line, "Unexpected character.")
+			s.error("Unexpected character.")
 		}
 	}
 }
@@ -140,7 +155,7 @@ func (s *Scanner) match(expected rune) bool {
 		return false
 	}
 
-	s.current++
+	s.advance()
 	return true
 }
 
@@ -176,28 +191,33 @@ func (s *Scanner) number() {
 		s.advance()
 	}
 
-	if s.peek() == '.' && s.isDigit(s.peek()) {
+	// Look for a fractional part.
+	if s.peek() == '.' && s.isDigit(s.peekNext()) {
 		// Consume the "."
 		s.advance()
+
+		for s.isDigit(s.peek()) {
+			s.advance()
+		}
 	}
 
 	num, err := strconv.ParseFloat(string(s.source[s.start:s.current]), 64)
 	if err != nil {
-		panic(err)
+		s.error("Invalid number.")
+		return
 	}
 	s.addTokenLiteral(token.NUMBER, num)
 }
 
 func (s *Scanner) string() {
 	for s.peek() != '"' && !s.isAtEnd() {
-		if s.peek() == '\n' {
-			s.line++
+		if s.advance() == '\n' {
+			s.newLine()
 		}
-		s.advance()
 	}
 
 	if s.isAtEnd() {
-		s.error(s.line, "Unterminated string.")
+		s.error("Unterminated string.")
 		return
 	}
 
@@ -209,19 +229,54 @@ func (s *Scanner) string() {
 	s.addTokenLiteral(token.STRING, string(value))
 }
 
-func (s *Scanner) ScanTokens() []*token.Token {
+// ScanTokens scans the whole source. Scanning goes on after an error so
+// that every bad character is reported; the tokens are only meaningful when
+// the returned error is nil.
+func (s *Scanner) ScanTokens() ([]*token.Token, error) {
 	for !s.isAtEnd() {
-		s.start = s.current
+		s.markStart()
 		s.scanToken()
 	}
 
-	eofToken := token.New(token.EOF, "", nil, s.line)
+	s.markStart()
+	eofToken := token.New(token.EOF, "", nil, s.span())
 	s.tokens = append(s.tokens, eofToken)
 
-	return s.tokens
+	return s.tokens, s.errs.Err()
+}
+
+func (s *Scanner) markStart() {
+	s.start = s.current
+	s.startPos = s.pos()
+}
+
+func (s *Scanner) newLine() {
+	s.line++
+	s.lineStart = s.current
+}
+
+// pos returns the position of the next character to scan.
+func (s *Scanner) pos() token.Position {
+	return token.Position{
+		File:   s.file,
+		Offset: s.offset,
+		Line:   s.line,
+		Column: s.current - s.lineStart + 1,
+	}
+}
+
+// span returns the range of the token being scanned.
+func (s *Scanner) span() token.Span {
+	return token.Span{
+		Start: s.startPos,
+		End:   s.pos(),
+	}
 }
 
-func (s *Scanner) error(line int, msg string) {
-	fmt.Printf("[line %d] Error: %s\n", line, msg)
-	os.Exit(1)
+func (s *Scanner) error(msg string) {
+	s.errs = append(s.errs, &loxerr.ScanError{
+		Position: s.startPos,
+		End:      s.pos(),
+		Msg:      msg,
+	})
 }
@@ -0,0 +1,339 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Exit codes of the lox command.
+const (
+	exitOK      = 0
+	exitUsage   = 64
+	exitCompile = 65
+	exitNoInput = 66
+	exitRuntime = 70
+)
+
+// loxBin is the lox command built once for the whole run.
+var loxBin string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "lox-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	loxBin = filepath.Join(dir, "lox")
+	build := exec.Command("go", "build", "-o", loxBin, "lox/cmd/lox")
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "building lox:", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// runLox runs the lox command in dir with args and stdin, returning its
+// stdout, stderr and exit code.
+func runLox(t *testing.T, dir string, stdin string, args ...string) (string, string, int) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(loxBin, args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	exitCode := 0
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Fatal(err)
+		}
+		exitCode = exitErr.ExitCode()
+	}
+	return stdout.String(), stderr.String(), exitCode
+}
+
+// runScriptCommand runs a conformance script with "lox run", checking its
+// output, the diagnostics it prints and its exit code.
+func runScriptCommand(t *testing.T, script string) {
+	source, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := parseExpectations(source)
+
+	stdout, stderr, exitCode := runLox(t, ".", "", "run", script)
+	checkOutput(t, exp.output, stdout)
+	checkDiagnostics(t, exp, stderr)
+
+	wantCode := exitOK
+	switch {
+	case exp.runtimeError != "":
+		wantCode = exitRuntime
+	case len(exp.errors) > 0:
+		wantCode = exitCompile
+	}
+	if exitCode != wantCode {
+		t.Errorf("exit code = %d, want %d\nstderr:\n%s", exitCode, wantCode, stderr)
+	}
+}
+
+// diagnostic matches the "file:line:column: message" lines of the lox
+// command. A runtime error raised in an imported module names the module's
+// file.
+var diagnostic = regexp.MustCompile(`^\S+:(\d+):\d+: (.*)$`)
+
+// checkDiagnostics compares the diagnostics in stderr with the expected ones.
+// The source excerpts and stack traces printed under them are ignored.
+func checkDiagnostics(t *testing.T, exp expectation, stderr string) {
+	t.Helper()
+
+	var compileErrors []string
+	var runtimeError string
+	for _, line := range strings.Split(stderr, "\n") {
+		m := diagnostic.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if msg, ok := strings.CutPrefix(m[2], "Runtime error: "); ok {
+			runtimeError = fmt.Sprintf("[line %s] %s", m[1], msg)
+		} else {
+			compileErrors = append(compileErrors, fmt.Sprintf("[line %s] %s", m[1], m[2]))
+		}
+	}
+
+	if got, want := strings.Join(compileErrors, "\n"), strings.Join(exp.errors, "\n"); got != want {
+		t.Errorf("compile errors:\n%s\nwant:\n%s", got, want)
+	}
+
+	var wantRuntime string
+	if exp.runtimeError != "" {
+		wantRuntime = fmt.Sprintf("[line %d] %s", exp.runtimeLine, exp.runtimeError)
+	}
+	if runtimeError != wantRuntime {
+		t.Errorf("runtime error:\n%s\nwant:\n%s", runtimeError, wantRuntime)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"args.lox":    "print argc;\nfor (var i = 0; i < argc; i = i + 1) print argv(i);\n",
+		"syntax.lox":  "print 1 +;\n",
+		"runtime.lox": "fun f() {\n  return nil.x;\n}\nf();\n",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		wantOut  string
+		wantErr  []string // prefixes of lines stderr must have
+		wantCode int
+	}{
+		{
+			name:    "run",
+			args:    []string{"run", "args.lox", "a", "b"},
+			wantOut: "2\na\nb\n",
+		},
+		{
+			name:    "script without run",
+			args:    []string{"args.lox", "only"},
+			wantOut: "1\nonly\n",
+		},
+		{
+			name:    "bytecode backend",
+			args:    []string{"-backend", "bytecode", "args.lox", "x"},
+			wantOut: "1\nx\n",
+		},
+		{
+			name:    "inline",
+			args:    []string{"-e", "print argv(0) + argv(1);", "x", "y"},
+			wantOut: "xy\n",
+		},
+		{
+			name:    "stdin",
+			args:    []string{"-", "z"},
+			stdin:   "print argv(0);\n",
+			wantOut: "z\n",
+		},
+		{
+			name:     "compile error",
+			args:     []string{"syntax.lox"},
+			wantErr:  []string{"syntax.lox:1:10: Error at ';': Expect expression.", "print 1 +;"},
+			wantCode: exitCompile,
+		},
+		{
+			name:     "runtime error",
+			args:     []string{"runtime.lox"},
+			wantErr:  []string{"runtime.lox:2:14: Runtime error: Only instances have properties.", "  at f (runtime.lox:2)", "  at script (runtime.lox:4)"},
+			wantCode: exitRuntime,
+		},
+		{
+			name:     "inline error",
+			args:     []string{"-e", "print nope;"},
+			wantErr:  []string{"<eval>:1:7: Runtime error: Undefined variable 'nope'."},
+			wantCode: exitRuntime,
+		},
+		{
+			name:     "missing script",
+			args:     []string{"run", "missing.lox"},
+			wantErr:  []string{"open missing.lox: no such file or directory"},
+			wantCode: exitNoInput,
+		},
+		{
+			name:     "missing run argument",
+			args:     []string{"run"},
+			wantErr:  []string{"Usage:"},
+			wantCode: exitUsage,
+		},
+		{
+			name:     "unknown backend",
+			args:     []string{"-backend", "jit", "args.lox"},
+			wantErr:  []string{"Unknown backend 'jit'."},
+			wantCode: exitUsage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runLox(t, dir, tt.stdin, tt.args...)
+			if stdout != tt.wantOut {
+				t.Errorf("stdout = %q, want %q", stdout, tt.wantOut)
+			}
+			checkStderr(t, stderr, tt.wantErr)
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+// checkStderr checks that stderr has a line starting with each of want, or
+// is empty when want is.
+func checkStderr(t *testing.T, stderr string, want []string) {
+	t.Helper()
+
+	lines := strings.Split(stderr, "\n")
+	for _, w := range want {
+		found := false
+		for _, line := range lines {
+			if strings.HasPrefix(line, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("stderr lacks %q:\n%s", w, stderr)
+		}
+	}
+	if len(want) == 0 && stderr != "" {
+		t.Errorf("stderr = %q, want nothing", stderr)
+	}
+}
+
+func TestREPL(t *testing.T) {
+	input := strings.Join([]string{
+		`var a = 1;`,
+		`a + 1`,
+		`fun f(n) {`,
+		`  return n * 10;`,
+		`}`,
+		`f(a)`,
+		`print "multi`,
+		`line";`,
+		`nope`,
+		`"still running"`,
+		`{"k": [1]}`,
+		`{ print "block"; }`,
+	}, "\n") + "\n"
+
+	for _, backend := range []string{"tree", "bytecode"} {
+		t.Run(backend, func(t *testing.T) {
+			stdout, stderr, code := runLox(t, ".", input, "-backend", backend)
+
+			want := "> > 2\n> ... ... > 10\n> ... multi\nline\n> > still running\n> {\"k\": [1]}\n> block\n> \n"
+			if stdout != want {
+				t.Errorf("stdout = %q, want %q", stdout, want)
+			}
+			checkStderr(t, stderr, []string{"<stdin>:1:1: Runtime error: Undefined variable 'nope'."})
+			if code != exitOK {
+				t.Errorf("exit code = %d, want %d", code, exitOK)
+			}
+		})
+	}
+}
+
+func TestCompileCommand(t *testing.T) {
+	dir := t.TempDir()
+	src := "fun greet(name) {\n  return \"hi \" + name;\n}\nprint greet(argv(0));\n"
+	if err := os.WriteFile(filepath.Join(dir, "greet.lox"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bad.loxc"), []byte("not bytecode"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The default output name replaces the extension.
+	if _, stderr, code := runLox(t, dir, "", "compile", "greet.lox"); code != exitOK {
+		t.Fatalf("compile: exit code %d\n%s", code, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "greet.loxc")); err != nil {
+		t.Errorf("compile: %v", err)
+	}
+
+	if _, stderr, code := runLox(t, dir, "", "compile", "greet.lox", "-o", "out.loxc"); code != exitOK {
+		t.Fatalf("compile -o: exit code %d\n%s", code, stderr)
+	}
+	for _, args := range [][]string{{"run", "out.loxc", "ada"}, {"out.loxc", "ada"}} {
+		if stdout, stderr, code := runLox(t, dir, "", args...); stdout != "hi ada\n" || code != exitOK {
+			t.Errorf("%v: stdout = %q, exit code %d\n%s", args, stdout, code, stderr)
+		}
+	}
+
+	// Without the source, the listing has no source lines.
+	for _, script := range []string{"greet.lox", "out.loxc"} {
+		stdout, stderr, code := runLox(t, dir, "", "disasm", script)
+		if code != exitOK {
+			t.Fatalf("disasm %s: exit code %d\n%s", script, code, stderr)
+		}
+		for _, want := range []string{"== script ==", "== greet ==", "CLOSURE"} {
+			if !strings.Contains(stdout, want) {
+				t.Errorf("disasm %s lacks %q:\n%s", script, want, stdout)
+			}
+		}
+		hasSource := strings.Contains(stdout, `2: return "hi " + name;`)
+		if hasSource != (script == "greet.lox") {
+			t.Errorf("disasm %s: source lines shown = %v:\n%s", script, hasSource, stdout)
+		}
+	}
+
+	_, stderr, code := runLox(t, dir, "", "run", "bad.loxc")
+	if code != exitCompile {
+		t.Errorf("run bad.loxc: exit code = %d, want %d", code, exitCompile)
+	}
+	checkStderr(t, stderr, []string{"bad.loxc: invalid compiled file: not a compiled Lox script"})
+
+	_, stderr, code = runLox(t, dir, "", "compile", "missing.lox")
+	if code != exitNoInput {
+		t.Errorf("compile missing.lox: exit code = %d, want %d\n%s", code, exitNoInput, stderr)
+	}
+}
@@ -0,0 +1,192 @@
+// Package test runs the .lox conformance suite in this directory, in the
+// format used by the Crafting Interpreters test suite:
+//
+//	print 1 + 2;        // expect: 3
+//	print nope;         // expect runtime error: Undefined variable 'nope'.
+//	var 1 = 2;          // Error at '1': Expect variable name.
+//	// [line 4] Error at end: Expect '}' after block.
+//
+// Each script runs in a fresh lox.VM, once on every backend, and through the
+// lox command. Its output must match its "expect:" lines in order, and the
+// error it fails with must match the expected compile errors or runtime
+// error; the command must also print them as diagnostics and exit with 65 or
+// 70. Directories named "lib" hold modules imported by the scripts and are
+// not run on their own.
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"lox"
+	"lox/loxerr"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// libDir names the directories holding modules for the import tests.
+const libDir = "lib"
+
+var (
+	expectOutput       = regexp.MustCompile(`// expect: ?(.*)`)
+	expectRuntimeError = regexp.MustCompile(`// expect runtime error: (.+)`)
+	expectError        = regexp.MustCompile(`// (Error.*)`)
+	expectErrorLine    = regexp.MustCompile(`// \[line (\d+)\] (Error.*)`)
+)
+
+var backends = []struct {
+	name    string
+	backend lox.Backend
+}{
+	{"tree", lox.TreeWalker},
+	{"bytecode", lox.Bytecode},
+}
+
+func TestConformance(t *testing.T) {
+	var scripts []string
+	err := filepath.WalkDir(".", func(path string, d os.DirEntry, err error) error {
+		if err == nil && d.IsDir() && d.Name() == libDir {
+			return filepath.SkipDir
+		}
+		if err == nil && !d.IsDir() && filepath.Ext(path) == ".lox" {
+			scripts = append(scripts, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) == 0 {
+		t.Fatal("no .lox scripts found")
+	}
+
+	for _, script := range scripts {
+		for _, b := range backends {
+			t.Run(b.name+"/"+strings.TrimSuffix(script, ".lox"), func(t *testing.T) {
+				t.Parallel()
+				runScript(t, script, b.backend)
+			})
+		}
+		t.Run("cli/"+strings.TrimSuffix(script, ".lox"), func(t *testing.T) {
+			t.Parallel()
+			runScriptCommand(t, script)
+		})
+	}
+}
+
+// expectation is what running one script should produce.
+type expectation struct {
+	output       []string
+	errors       []string // compile errors, as "[line N] Error..."
+	runtimeError string
+	runtimeLine  int
+}
+
+func parseExpectations(source []byte) expectation {
+	var exp expectation
+	lines := bufio.NewScanner(bytes.NewReader(source))
+	for line := 1; lines.Scan(); line++ {
+		text := lines.Text()
+		if m := expectOutput.FindStringSubmatch(text); m != nil {
+			exp.output = append(exp.output, m[1])
+		} else if m := expectRuntimeError.FindStringSubmatch(text); m != nil {
+			exp.runtimeError = m[1]
+			exp.runtimeLine = line
+		} else if m := expectErrorLine.FindStringSubmatch(text); m != nil {
+			exp.errors = append(exp.errors, fmt.Sprintf("[line %s] %s", m[1], m[2]))
+		} else if m := expectError.FindStringSubmatch(text); m != nil {
+			exp.errors = append(exp.errors, fmt.Sprintf("[line %d] %s", line, m[1]))
+		}
+	}
+
+	return exp
+}
+
+func runScript(t *testing.T, script string, backend lox.Backend) {
+	source, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := parseExpectations(source)
+
+	var stdout bytes.Buffer
+	vm := lox.NewVM(lox.Options{Stdout: &stdout, Backend: backend})
+	_, err = vm.EvalSource(script, string(source))
+
+	checkOutput(t, exp.output, stdout.String())
+	checkErrors(t, exp, err)
+}
+
+func checkOutput(t *testing.T, want []string, stdout string) {
+	t.Helper()
+
+	got := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	if stdout == "" {
+		got = nil
+	}
+
+	for i := 0; i < len(want) || i < len(got); i++ {
+		switch {
+		case i >= len(got):
+			t.Errorf("missing output line %d: %q", i+1, want[i])
+		case i >= len(want):
+			t.Errorf("unexpected output line %d: %q", i+1, got[i])
+		case got[i] != want[i]:
+			t.Errorf("output line %d = %q, want %q", i+1, got[i], want[i])
+		}
+	}
+}
+
+// checkErrors compares the error returned by the VM with the expected
+// compile errors or runtime error.
+func checkErrors(t *testing.T, exp expectation, err error) {
+	t.Helper()
+
+	var compileErrors []string
+	var runtimeError string
+	var list loxerr.List
+	var rtErr *loxerr.RuntimeError
+	switch {
+	case err == nil:
+	case errors.As(err, &rtErr):
+		runtimeError = fmt.Sprintf("[line %d] %s", rtErr.Line, rtErr.Msg)
+	case errors.As(err, &list):
+		for _, err := range list {
+			compileErrors = append(compileErrors, formatCompileError(err))
+		}
+	default:
+		t.Fatalf("unexpected error type %T: %v", err, err)
+	}
+
+	if got, want := strings.Join(compileErrors, "\n"), strings.Join(exp.errors, "\n"); got != want {
+		t.Errorf("compile errors:\n%s\nwant:\n%s", got, want)
+	}
+
+	var wantRuntime string
+	if exp.runtimeError != "" {
+		wantRuntime = fmt.Sprintf("[line %d] %s", exp.runtimeLine, exp.runtimeError)
+	}
+	if runtimeError != wantRuntime {
+		t.Errorf("runtime error:\n%s\nwant:\n%s", runtimeError, wantRuntime)
+	}
+}
+
+// formatCompileError renders err like the test suite annotations:
+// "[line N] Error at 'x': message".
+func formatCompileError(err error) string {
+	switch err := err.(type) {
+	case *loxerr.ScanError:
+		return fmt.Sprintf("[line %d] Error: %s", err.Line, err.Msg)
+	case *loxerr.ParseError:
+		return fmt.Sprintf("[line %d] Error%s: %s", err.Line, err.Where, err.Msg)
+	case *loxerr.ResolveError:
+		return fmt.Sprintf("[line %d] Error%s: %s", err.Line, err.Where, err.Msg)
+	case *loxerr.CompileError:
+		return fmt.Sprintf("[line %d] Error: %s", err.Line, err.Msg)
+	}
+	return err.Error()
+}
@@ -6,17 +6,20 @@ type Type string
 
 const (
 	// Single-character tokens.
-	LEFT_PAREN  Type = "("
-	RIGHT_PAREN Type = ")"
-	LEFT_BRACE  Type = "{"
-	RIGHT_BRACE Type = "}"
-	COMMA       Type = ","
-	DOT         Type = "."
-	MINUS       Type = "-"
-	PLUS        Type = "+"
-	SEMICOLON   Type = ";"
-	SLASH       Type = "\\"
-	STAR        Type = "*"
+	LEFT_PAREN    Type = "("
+	RIGHT_PAREN   Type = ")"
+	LEFT_BRACE    Type = "{"
+	RIGHT_BRACE   Type = "}"
+	LEFT_BRACKET  Type = "["
+	RIGHT_BRACKET Type = "]"
+	COMMA         Type = ","
+	DOT           Type = "."
+	MINUS         Type = "-"
+	PLUS          Type = "+"
+	SEMICOLON     Type = ";"
+	COLON         Type = ":"
+	SLASH         Type = "\\"
+	STAR          Type = "*"
 
 	// One or two character tokens.
 	BANG          Type = "!"
@@ -34,41 +37,102 @@ const (
 	NUMBER     Type = "NUMBER"
 
 	// Keywords.
-	AND    Type = "AND"
-	CLASS  Type = "CLASS"
-	ELSE   Type = "ESLE"
-	FALSE  Type = "FALSE"
-	FUN    Type = "FUN"
-	FOR    Type = "FOR"
-	IF     Type = "IF"
-	NIL    Type = "NIL"
-	OR     Type = "OR"
-	PRINT  Type = "PRINT"
-	RETURN Type = "RETURN"
-	SUPER  Type = "SUPER"
-	THIS   Type = "THIS"
-	TRUE   Type = "TRUE"
-	VAR    Type = "VAR"
-	WHILE  Type = "WHILE"
+	AND      Type = "AND"
+	BREAK    Type = "BREAK"
+	CATCH    Type = "CATCH"
+	CLASS    Type = "CLASS"
+	CONTINUE Type = "CONTINUE"
+	ELSE     Type = "ESLE"
+	FALSE    Type = "FALSE"
+	FINALLY  Type = "FINALLY"
+	FUN      Type = "FUN"
+	FOR      Type = "FOR"
+	IF       Type = "IF"
+	IMPORT   Type = "IMPORT"
+	NIL      Type = "NIL"
+	OR       Type = "OR"
+	PRINT    Type = "PRINT"
+	RETURN   Type = "RETURN"
+	SUPER    Type = "SUPER"
+	THIS     Type = "THIS"
+	THROW    Type = "THROW"
+	TRUE     Type = "TRUE"
+	TRY      Type = "TRY"
+	VAR      Type = "VAR"
+	WHILE    Type = "WHILE"
 
 	EOF Type = "EOF"
 
 	UNKNOWN Type = "unknown"
 )
 
+// Position describes a location in a source file.
+type Position struct {
+	File   string
+	Offset int // 0-based, in bytes
+	Line   int // 1-based
+	Column int // 1-based, counted in runes
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns "file:line:column", leaving out the parts that are unknown.
+func (p Position) String() string {
+	s := p.File
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return s
+}
+
+// Span is the source range covered by a token or a syntax tree node. End is
+// the position just after the last character.
+type Span struct {
+	Start Position
+	End   Position
+}
+
+// Join returns the smallest span covering both s and other.
+func (s Span) Join(other Span) Span {
+	if !s.Start.IsValid() {
+		return other
+	}
+	if !other.Start.IsValid() {
+		return s
+	}
+
+	joined := s
+	if other.Start.Offset < joined.Start.Offset {
+		joined.Start = other.Start
+	}
+	if other.End.Offset > joined.End.Offset {
+		joined.End = other.End
+	}
+	return joined
+}
+
+func (s Span) String() string {
+	return s.Start.String()
+}
+
 type Token struct {
 	tokenType Type
 	lexeme    string
 	literal   any
-	line      int
+	span      Span
 }
 
-func New(t Type, lexeme string, literal any, line int) *Token {
+func New(t Type, lexeme string, literal any, span Span) *Token {
 	return &Token{
 		tokenType: t,
 		lexeme:    lexeme,
 		literal:   literal,
-		line:      line,
+		span:      span,
 	}
 }
 
@@ -84,6 +148,22 @@ func (t *Token) Lexeme() string {
 	return t.lexeme
 }
 
+func (t *Token) Pos() Position {
+	return t.span.Start
+}
+
+func (t *Token) End() Position {
+	return t.span.End
+}
+
+func (t *Token) Span() Span {
+	return t.span
+}
+
+func (t *Token) Line() int {
+	return t.span.Start.Line
+}
+
 func (t *Token) String() string {
 	return fmt.Sprintf("%s %s %v", t.tokenType, t.lexeme, t.literal)
 }
@@ -92,18 +172,28 @@ func ToToken(text string) Type {
 	switch text {
 	case "and":
 		return AND
+	case "break":
+		return BREAK
+	case "catch":
+		return CATCH
 	case "class":
 		return CLASS
+	case "continue":
+		return CONTINUE
 	case "else":
 		return ELSE
 	case "false":
 		return FALSE
+	case "finally":
+		return FINALLY
 	case "for":
 		return FOR
 	case "fun":
 		return FUN
 	case "if":
 		return IF
+	case "import":
+		return IMPORT
 	case "nil":
 		return NIL
 	case "or":
@@ -116,8 +206,12 @@ func ToToken(text string) Type {
 		return SUPER
 	case "this":
 		return THIS
+	case "throw":
+		return THROW
 	case "true":
 		return TRUE
+	case "try":
+		return TRY
 	case "var":
 		return VAR
 	case "while":
@@ -0,0 +1,194 @@
+package vm
+
+import (
+	"fmt"
+	"lox/interpreter"
+)
+
+// pushFrame starts a call of c, whose argc arguments are on top of the
+// stack above slot 0.
+func (vm *VM) pushFrame(c *Closure, argc int, name string) {
+	if max := vm.limits.MaxCallDepth; max > 0 && vm.callDepth() >= max {
+		panic(vm.error("Stack overflow."))
+	}
+	vm.frames = append(vm.frames, frame{closure: c, base: len(vm.stack) - argc - 1, name: name})
+}
+
+// callDepth returns the number of active calls, as the tree-walker counts
+// them: every frame but the main script's.
+func (vm *VM) callDepth() int {
+	if len(vm.frames) > 0 && vm.frames[0].main {
+		return len(vm.frames) - 1
+	}
+	return len(vm.frames)
+}
+
+func (vm *VM) checkArity(callee interpreter.Callable, argc int) {
+	if arity := callee.Arity(); arity != interpreter.Variadic && argc != arity {
+		panic(vm.error(fmt.Sprintf("Expected %d arguments but got %d.", arity, argc)))
+	}
+}
+
+// callValue calls callee with the argc arguments on top of the stack.
+// Closures get a frame run by the current loop; everything else runs to
+// completion and leaves its result in place of the callee.
+func (vm *VM) callValue(callee any, argc int) {
+	slot0 := len(vm.stack) - argc - 1
+	switch c := callee.(type) {
+	case *Closure:
+		vm.checkArity(c, argc)
+		vm.pushFrame(c, argc, c.fn.Name)
+	case *BoundMethod:
+		vm.checkArity(c, argc)
+		vm.stack[slot0] = c.receiver
+		vm.pushFrame(c.method, argc, c.method.fn.Name)
+	case *interpreter.Class:
+		vm.checkArity(c, argc)
+		ins := interpreter.NewInstance(c)
+		vm.stack[slot0] = ins
+		switch init := c.FindMethod("init").(type) {
+		case nil:
+		case *Closure:
+			// The frame is named after the class, like in the interpreter.
+			vm.pushFrame(init, argc, c.Name())
+			return
+		default:
+			vm.callBuiltin(init.Bind(ins), argc)
+		}
+		vm.stack = vm.stack[:slot0+1]
+		vm.stack[slot0] = ins
+	case interpreter.Callable:
+		vm.checkArity(c, argc)
+		vm.callBuiltin(c, argc)
+	default:
+		panic(vm.error("Can only call functions and classes."))
+	}
+}
+
+// callBuiltin calls a function implemented in Go, replacing it and its
+// argc arguments with its result. Its errors are reported at the call.
+func (vm *VM) callBuiltin(callee interpreter.Callable, argc int) {
+	args := make([]any, argc)
+	copy(args, vm.stack[len(vm.stack)-argc:])
+	vm.stack = vm.stack[:len(vm.stack)-argc-1]
+
+	b, ok := callee.(interpreter.Builtin)
+	if !ok {
+		vm.push(callee.Call(vm, args))
+		return
+	}
+	val, err := b.CallBuiltin(vm, args)
+	if err != nil {
+		rtErr := vm.error(err.Error())
+		rtErr.Cause = err
+		panic(rtErr)
+	}
+	vm.push(val)
+}
+
+// invoke calls the method name of the value below the argc arguments on
+// top of the stack, without creating a bound method for it.
+func (vm *VM) invoke(name string, argc int) {
+	receiver := vm.peek(argc)
+	ins, ok := receiver.(*interpreter.Instance)
+	if !ok {
+		callee := vm.getProperty(receiver, name)
+		vm.stack[len(vm.stack)-argc-1] = callee
+		vm.callValue(callee, argc)
+		return
+	}
+
+	if field, ok := ins.Field(name); ok {
+		vm.stack[len(vm.stack)-argc-1] = field
+		vm.callValue(field, argc)
+		return
+	}
+	switch method := ins.Class().FindMethod(name).(type) {
+	case nil:
+		panic(vm.error(fmt.Sprintf("Undefined property '%s'.", name)))
+	case *Closure:
+		vm.checkArity(method, argc)
+		vm.pushFrame(method, argc, name)
+	default:
+		vm.callValue(method.Bind(ins), argc)
+	}
+}
+
+// iterator produces the values of a for-in loop.
+type iterator struct {
+	next func() (any, bool)
+}
+
+// iterate returns an iterator over a list, map, string or iterable
+// instance.
+func (vm *VM) iterate(iterable any) *iterator {
+	switch iterable := iterable.(type) {
+	case *interpreter.List:
+		// The length is read on every iteration, so elements pushed by the
+		// body are visited too.
+		n := 0
+		return &iterator{next: func() (any, bool) {
+			elements := iterable.Elements()
+			if n >= len(elements) {
+				return nil, false
+			}
+			n++
+			return elements[n-1], true
+		}}
+	case *interpreter.Map:
+		// Iterate over a copy of the keys, so the body may add or delete
+		// entries.
+		return sliceIterator(append([]any(nil), iterable.Keys()...))
+	case string:
+		var chars []any
+		for _, r := range iterable {
+			chars = append(chars, string(r))
+		}
+		return sliceIterator(chars)
+	case *interpreter.Instance:
+		if iterable.Class().FindMethod("iter") != nil {
+			return vm.iterateInstance(iterable)
+		}
+	}
+	panic(vm.error("Can only iterate over lists, maps, strings and iterable instances."))
+}
+
+func sliceIterator(values []any) *iterator {
+	return &iterator{next: func() (any, bool) {
+		if len(values) == 0 {
+			return nil, false
+		}
+		v := values[0]
+		values = values[1:]
+		return v, true
+	}}
+}
+
+// iterateInstance runs the iterator protocol: ins.iter() returns an
+// iterator, and the loop calls its next() until done() is true.
+func (vm *VM) iterateInstance(ins *interpreter.Instance) *iterator {
+	obj, ok := vm.invokeMethod(ins, "iter").(interpreter.Object)
+	if !ok {
+		panic(vm.error("iter() must return an object with next() and done() methods."))
+	}
+	return &iterator{next: func() (any, bool) {
+		if isTruthy(vm.invokeMethod(obj, "done")) {
+			return nil, false
+		}
+		return vm.invokeMethod(obj, "next"), true
+	}}
+}
+
+// invokeMethod calls the method name of obj without arguments.
+func (vm *VM) invokeMethod(obj interpreter.Object, name string) any {
+	callee, ok := obj.Get(vm.token(name)).(interpreter.Callable)
+	if !ok {
+		panic(vm.error(fmt.Sprintf("'%s' must be a method.", name)))
+	}
+
+	val, err := vm.Callback(callee)
+	if err != nil {
+		panic(vm.error(err.Error()))
+	}
+	return val
+}
@@ -0,0 +1,114 @@
+package vm
+
+import (
+	"lox/bytecode"
+	"lox/env"
+	"lox/interpreter"
+)
+
+var (
+	_ interpreter.Callable = (*Closure)(nil)
+	_ interpreter.Method   = (*Closure)(nil)
+	_ interpreter.Callable = (*BoundMethod)(nil)
+)
+
+// Closure is a compiled function together with the variables it captured.
+type Closure struct {
+	fn       *bytecode.Function
+	upvalues []*Upvalue
+	globals  *env.Env // of the module declaring the function
+}
+
+func (c *Closure) Arity() int {
+	return c.fn.Arity
+}
+
+// Call runs c on the VM that created it, which owns its captured
+// variables.
+func (c *Closure) Call(rt interpreter.Runtime, arguments []any) any {
+	return rt.(*VM).callClosure(c, c, arguments, c.fn.Name)
+}
+
+func (c *Closure) Bind(ins *interpreter.Instance) interpreter.Callable {
+	return &BoundMethod{receiver: ins, method: c}
+}
+
+func (c *Closure) String() string {
+	return "<fn " + c.fn.Name + ">"
+}
+
+// BoundMethod is a method read from an instance, which it receives as this.
+type BoundMethod struct {
+	receiver *interpreter.Instance
+	method   *Closure
+}
+
+func (b *BoundMethod) Arity() int {
+	return b.method.Arity()
+}
+
+func (b *BoundMethod) Call(rt interpreter.Runtime, arguments []any) any {
+	return rt.(*VM).callClosure(b.method, b.receiver, arguments, b.method.fn.Name)
+}
+
+func (b *BoundMethod) String() string {
+	return b.method.String()
+}
+
+// Upvalue is a variable captured by a closure. It refers to a stack slot
+// while the variable is in scope, and holds the value once it is closed.
+type Upvalue struct {
+	vm     *VM
+	slot   int
+	open   bool
+	closed any
+}
+
+func (u *Upvalue) get() any {
+	if u.open {
+		return u.vm.stack[u.slot]
+	}
+	return u.closed
+}
+
+func (u *Upvalue) set(v any) {
+	if u.open {
+		u.vm.stack[u.slot] = v
+	} else {
+		u.closed = v
+	}
+}
+
+// captureUpvalue returns the upvalue for slot, shared by every closure
+// capturing it.
+func (vm *VM) captureUpvalue(slot int) *Upvalue {
+	n := len(vm.openUpvalues)
+	for i := n - 1; i >= 0 && vm.openUpvalues[i].slot >= slot; i-- {
+		if vm.openUpvalues[i].slot == slot {
+			return vm.openUpvalues[i]
+		}
+	}
+
+	u := &Upvalue{vm: vm, slot: slot, open: true}
+	// Keep the open upvalues sorted by slot.
+	i := n
+	for i > 0 && vm.openUpvalues[i-1].slot > slot {
+		i--
+	}
+	vm.openUpvalues = append(vm.openUpvalues, nil)
+	copy(vm.openUpvalues[i+1:], vm.openUpvalues[i:])
+	vm.openUpvalues[i] = u
+	return u
+}
+
+// closeUpvalues moves the variables in the slots from last up to the heap.
+func (vm *VM) closeUpvalues(last int) {
+	n := len(vm.openUpvalues)
+	for n > 0 && vm.openUpvalues[n-1].slot >= last {
+		u := vm.openUpvalues[n-1]
+		u.closed = vm.stack[u.slot]
+		u.open = false
+		n--
+	}
+	vm.openUpvalues = vm.openUpvalues[:n]
+}
@@ -0,0 +1,476 @@
+package vm
+
+import (
+	"fmt"
+	"lox/bytecode"
+	"lox/interpreter"
+	"lox/loxerr"
+	"lox/token"
+)
+
+// contextCheckInterval is how many instructions run between two checks of
+// the run's context.
+const contextCheckInterval = 1024
+
+// run executes instructions until the frame at index depth returns, and
+// returns its result. Runtime errors raised meanwhile jump to the innermost
+// handler of a try block in these frames; the others unwind to the caller
+// of run.
+func (vm *VM) run(depth int) any {
+	for {
+		if result, done := vm.protect(depth); done {
+			return result
+		}
+	}
+}
+
+// protect runs execute, recovering the runtime errors it can catch. It
+// reports done once execute returns.
+func (vm *VM) protect(depth int) (result any, done bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			rtErr := vm.asRuntimeError(r)
+			if !vm.catch(rtErr, depth) {
+				panic(rtErr)
+			}
+		}
+	}()
+
+	return vm.execute(depth), true
+}
+
+// catch unwinds to the innermost handler, if it belongs to the frames run
+// from depth up and may catch err, and makes it the next instruction with
+// err on top of the stack.
+func (vm *VM) catch(err *loxerr.RuntimeError, depth int) bool {
+	n := len(vm.handlers) - 1
+	if n < 0 || vm.handlers[n].frame < depth || !interpreter.Catchable(vm.ctx, err) {
+		return false
+	}
+
+	h := vm.handlers[n]
+	vm.handlers = vm.handlers[:n]
+	vm.closeUpvalues(h.stack)
+	vm.stack = vm.stack[:h.stack]
+	vm.frames = vm.frames[:h.frame+1]
+	vm.frames[h.frame].ip = h.pc
+	vm.push(err)
+	return true
+}
+
+func (vm *VM) execute(depth int) any {
+	f := &vm.frames[len(vm.frames)-1]
+	code := f.closure.fn.Chunk.Code
+	constants := f.closure.fn.Chunk.Constants
+
+	// reload refreshes the cached frame after the instructions that may
+	// push or pop frames, or run Lox code from Go, which may move them.
+	reload := func() {
+		f = &vm.frames[len(vm.frames)-1]
+		code = f.closure.fn.Chunk.Code
+		constants = f.closure.fn.Chunk.Constants
+	}
+	readByte := func() byte {
+		f.ip++
+		return code[f.ip-1]
+	}
+	readShort := func() int {
+		f.ip += 2
+		return int(code[f.ip-2])<<8 | int(code[f.ip-1])
+	}
+	readString := func() string {
+		return constants[readShort()].(string)
+	}
+
+	for {
+		vm.step()
+
+		switch op := bytecode.OpCode(readByte()); op {
+		case bytecode.OpConstant:
+			vm.push(constants[readShort()])
+		case bytecode.OpNil:
+			vm.push(nil)
+		case bytecode.OpTrue:
+			vm.push(true)
+		case bytecode.OpFalse:
+			vm.push(false)
+		case bytecode.OpPop:
+			vm.pop()
+		case bytecode.OpDup:
+			vm.push(vm.peek(0))
+
+		case bytecode.OpGetLocal:
+			vm.push(vm.stack[f.base+readShort()])
+		case bytecode.OpSetLocal:
+			vm.stack[f.base+readShort()] = vm.peek(0)
+		case bytecode.OpGetGlobal:
+			vm.push(vm.getGlobal(f.closure, readString()))
+		case bytecode.OpDefineGlobal:
+			f.closure.globals.Define(readString(), vm.pop())
+		case bytecode.OpSetGlobal:
+			vm.setGlobal(f.closure, readString(), vm.peek(0))
+		case bytecode.OpGetUpvalue:
+			vm.push(f.closure.upvalues[readShort()].get())
+		case bytecode.OpSetUpvalue:
+			f.closure.upvalues[readShort()].set(vm.peek(0))
+
+		case bytecode.OpGetProperty:
+			name := readString()
+			vm.push(vm.getProperty(vm.pop(), name))
+		case bytecode.OpSetProperty:
+			name := readString()
+			value := vm.pop()
+			vm.setProperty(vm.pop(), name, value)
+			vm.push(value)
+		case bytecode.OpGetSuper:
+			name := readString()
+			superClass := vm.pop().(*interpreter.Class)
+			this := vm.pop().(*interpreter.Instance)
+			method := superClass.FindMethod(name)
+			if method == nil {
+				panic(vm.error(fmt.Sprintf("Undefined property '%s'.", name)))
+			}
+			vm.push(method.Bind(this))
+		case bytecode.OpGetIndex:
+			index := vm.pop()
+			vm.push(vm.indexable(vm.pop()).Index(vm.token(""), index))
+		case bytecode.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			vm.indexable(vm.pop()).SetIndex(vm.token(""), index, value)
+			vm.push(value)
+
+		case bytecode.OpEqual:
+			right := vm.pop()
+			vm.push(interpreter.Equal(vm.pop(), right))
+		case bytecode.OpNotEqual:
+			right := vm.pop()
+			vm.push(!interpreter.Equal(vm.pop(), right))
+		case bytecode.OpGreater:
+			l, r := vm.numberOperands()
+			vm.push(l > r)
+		case bytecode.OpGreaterEqual:
+			l, r := vm.numberOperands()
+			vm.push(l >= r)
+		case bytecode.OpLess:
+			l, r := vm.numberOperands()
+			vm.push(l < r)
+		case bytecode.OpLessEqual:
+			l, r := vm.numberOperands()
+			vm.push(l <= r)
+		case bytecode.OpAdd:
+			vm.add()
+		case bytecode.OpSubtract:
+			l, r := vm.numberOperands()
+			vm.push(l - r)
+		case bytecode.OpMultiply:
+			l, r := vm.numberOperands()
+			vm.push(l * r)
+		case bytecode.OpDivide:
+			l, r := vm.numberOperands()
+			vm.push(l / r)
+		case bytecode.OpNot:
+			vm.push(!isTruthy(vm.pop()))
+		case bytecode.OpNegate:
+			v, ok := vm.peek(0).(float64)
+			if !ok {
+				panic(vm.error("Operand must be a number."))
+			}
+			vm.stack[len(vm.stack)-1] = -v
+
+		case bytecode.OpPrint:
+			fmt.Fprintln(vm.Stdout(), interpreter.Stringify(vm.pop()))
+
+		case bytecode.OpJump:
+			offset := readShort()
+			f.ip += offset
+		case bytecode.OpJumpIfFalse:
+			offset := readShort()
+			if !isTruthy(vm.peek(0)) {
+				f.ip += offset
+			}
+		case bytecode.OpLoop:
+			offset := readShort()
+			f.ip -= offset
+
+		case bytecode.OpCall:
+			argc := int(readByte())
+			vm.callValue(vm.peek(argc), argc)
+			reload()
+		case bytecode.OpInvoke:
+			name := readString()
+			argc := int(readByte())
+			vm.invoke(name, argc)
+			reload()
+		case bytecode.OpClosure:
+			fn := constants[readShort()].(*bytecode.Function)
+			closure := &Closure{fn: fn, upvalues: make([]*Upvalue, fn.UpvalueCount), globals: f.closure.globals}
+			for n := range closure.upvalues {
+				isLocal := readByte() == 1
+				index := readShort()
+				if isLocal {
+					closure.upvalues[n] = vm.captureUpvalue(f.base + index)
+				} else {
+					closure.upvalues[n] = f.closure.upvalues[index]
+				}
+			}
+			vm.push(closure)
+		case bytecode.OpCloseUpvalue:
+			vm.closeUpvalues(len(vm.stack) - 1)
+			vm.pop()
+		case bytecode.OpReturn:
+			result := vm.pop()
+			vm.closeUpvalues(f.base)
+			vm.stack = vm.stack[:f.base]
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			if len(vm.frames) == depth {
+				return result
+			}
+			vm.push(result)
+			reload()
+
+		case bytecode.OpClass, bytecode.OpSubclass:
+			name := readString()
+			count := readShort()
+			methods := make(map[string]interpreter.Method, count)
+			for _, m := range vm.stack[len(vm.stack)-count:] {
+				closure := m.(*Closure)
+				methods[closure.fn.Name] = closure
+			}
+			vm.stack = vm.stack[:len(vm.stack)-count]
+			var superClass *interpreter.Class
+			if op == bytecode.OpSubclass {
+				superClass = vm.peek(0).(*interpreter.Class)
+			}
+			vm.push(interpreter.NewClass(name, methods, superClass))
+		case bytecode.OpSuperclass:
+			if _, ok := vm.peek(0).(*interpreter.Class); !ok {
+				panic(vm.error("Superclass must be a class."))
+			}
+
+		case bytecode.OpList:
+			count := readShort()
+			elements := make([]any, count)
+			copy(elements, vm.stack[len(vm.stack)-count:])
+			vm.stack = vm.stack[:len(vm.stack)-count]
+			vm.push(interpreter.NewList(elements))
+		case bytecode.OpMap:
+			count := readShort()
+			entries := vm.stack[len(vm.stack)-2*count:]
+			m := interpreter.NewMap()
+			for n := 0; n < len(entries); n += 2 {
+				if err := m.Put(entries[n], entries[n+1]); err != nil {
+					panic(vm.error(err.Error()))
+				}
+			}
+			vm.stack = vm.stack[:len(vm.stack)-2*count]
+			vm.push(m)
+
+		case bytecode.OpIter:
+			vm.push(vm.iterate(vm.pop()))
+			reload()
+		case bytecode.OpIterNext:
+			offset := readShort()
+			v, ok := vm.peek(0).(*iterator).next()
+			reload()
+			if ok {
+				vm.push(v)
+			} else {
+				f.ip += offset
+			}
+
+		case bytecode.OpThrow:
+			value := vm.pop()
+			rtErr := vm.error("")
+			rtErr.Trace = vm.stackTrace(rtErr.Position)
+			panic(interpreter.Throw(rtErr, value))
+		case bytecode.OpTry:
+			offset := readShort()
+			vm.handlers = append(vm.handlers, handler{
+				frame: len(vm.frames) - 1,
+				stack: len(vm.stack),
+				pc:    f.ip + offset,
+			})
+		case bytecode.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		case bytecode.OpErrorValue:
+			vm.push(interpreter.ErrorValue(vm.pop().(*loxerr.RuntimeError)))
+		case bytecode.OpRethrow:
+			panic(vm.pop().(*loxerr.RuntimeError))
+
+		case bytecode.OpImport:
+			path := readString()
+			vm.push(vm.importModule(path))
+			reload()
+
+		default:
+			panic(fmt.Sprintf("unknown opcode %v", op))
+		}
+	}
+}
+
+func (vm *VM) push(v any) {
+	vm.stack = append(vm.stack, v)
+}
+
+func (vm *VM) pop() any {
+	v := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return v
+}
+
+// peek returns the value distance slots below the top of the stack.
+func (vm *VM) peek(distance int) any {
+	return vm.stack[len(vm.stack)-1-distance]
+}
+
+// step accounts for executing an instruction, raising a runtime error when
+// the run is over budget or cancelled.
+func (vm *VM) step() {
+	vm.steps++
+	if max := vm.limits.MaxSteps; max > 0 && vm.steps > max {
+		rtErr := vm.error("Execution limit exceeded.")
+		rtErr.Cause = interpreter.ErrStepLimit
+		panic(rtErr)
+	}
+	if vm.steps%contextCheckInterval == 0 {
+		if err := vm.ctx.Err(); err != nil {
+			rtErr := vm.error("Execution interrupted: " + err.Error() + ".")
+			rtErr.Cause = err
+			panic(rtErr)
+		}
+	}
+}
+
+// span returns the source of the instruction being executed.
+func (vm *VM) span() token.Span {
+	if len(vm.frames) == 0 {
+		return token.Span{}
+	}
+	f := vm.frames[len(vm.frames)-1]
+	return f.closure.fn.Chunk.SpanAt(f.ip - 1)
+}
+
+// error returns a runtime error at the instruction being executed.
+func (vm *VM) error(msg string) *loxerr.RuntimeError {
+	span := vm.span()
+	return &loxerr.RuntimeError{Position: span.Start, End: span.End, Msg: msg}
+}
+
+// token returns a token for name at the instruction being executed, for the
+// methods of values that report errors at a token.
+func (vm *VM) token(name string) *token.Token {
+	return token.New(token.IDENTIFIER, name, nil, vm.span())
+}
+
+func (vm *VM) getGlobal(c *Closure, name string) any {
+	for e := c.globals; e != nil; e = e.Enclosing() {
+		if val, ok := e.Lookup(name); ok {
+			return val
+		}
+	}
+	panic(vm.error(fmt.Sprintf("Undefined variable '%s'.", name)))
+}
+
+func (vm *VM) setGlobal(c *Closure, name string, val any) {
+	for e := c.globals; e != nil; e = e.Enclosing() {
+		if _, ok := e.Lookup(name); ok {
+			e.Define(name, val)
+			return
+		}
+	}
+	panic(vm.error(fmt.Sprintf("Undefined variable '%s'.", name)))
+}
+
+func (vm *VM) getProperty(obj any, name string) any {
+	if ins, ok := obj.(*interpreter.Instance); ok {
+		if val, ok := ins.Field(name); ok {
+			return val
+		}
+		if method := ins.Class().FindMethod(name); method != nil {
+			return method.Bind(ins)
+		}
+		panic(vm.error(fmt.Sprintf("Undefined property '%s'.", name)))
+	}
+
+	o, ok := obj.(interpreter.Object)
+	if !ok {
+		panic(vm.error("Only instances have properties."))
+	}
+	return o.Get(vm.token(name))
+}
+
+func (vm *VM) setProperty(obj any, name string, value any) {
+	if ins, ok := obj.(*interpreter.Instance); ok {
+		ins.SetField(name, value)
+		return
+	}
+
+	o, ok := obj.(interpreter.Object)
+	if !ok {
+		panic(vm.error("Only instances have fields."))
+	}
+	o.Set(vm.token(name), value)
+}
+
+func (vm *VM) indexable(obj any) interpreter.Indexable {
+	indexable, ok := obj.(interpreter.Indexable)
+	if !ok {
+		panic(vm.error("Only lists and maps can be indexed."))
+	}
+	return indexable
+}
+
+func (vm *VM) numberOperands() (float64, float64) {
+	l, lok := vm.peek(1).(float64)
+	r, rok := vm.peek(0).(float64)
+	if !lok || !rok {
+		panic(vm.error("Operands must be numbers."))
+	}
+	vm.stack = vm.stack[:len(vm.stack)-2]
+	return l, r
+}
+
+func (vm *VM) add() {
+	switch l := vm.peek(1).(type) {
+	case float64:
+		if r, ok := vm.peek(0).(float64); ok {
+			vm.stack = vm.stack[:len(vm.stack)-2]
+			vm.push(l + r)
+			return
+		}
+	case string:
+		if r, ok := vm.peek(0).(string); ok {
+			vm.stack = vm.stack[:len(vm.stack)-2]
+			vm.push(l + r)
+			return
+		}
+	}
+	panic(vm.error("Operands must be two numbers or two strings."))
+}
+
+// importModule loads the module at path for the import being executed.
+func (vm *VM) importModule(path string) *interpreter.Module {
+	if vm.importer == nil {
+		panic(vm.error("Imports are not supported."))
+	}
+
+	vm.importing = path
+	m, err := vm.importer(vm.span().Start.File, path)
+	if err != nil {
+		panic(vm.error(err.Error()))
+	}
+	return m
+}
+
+// isTruthy follows Ruby's rule: nil and false are falsey, everything else
+// is truthy.
+func isTruthy(v any) bool {
+	if v == nil {
+		return false
+	}
+	if b, ok := v.(bool); ok {
+		return b
+	}
+	return true
+}
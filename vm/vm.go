@@ -0,0 +1,229 @@
+// Package vm runs the bytecode produced by lox/compiler on a stack machine.
+//
+// It offers the same API as the tree-walking interpreter and shares its
+// values: classes, instances, lists, maps and native functions come from
+// lox/interpreter, and so do the built-ins and globals, through
+// interpreter.Host, so host bindings work on either runtime.
+package vm
+
+import (
+	"context"
+	"fmt"
+	"lox/ast"
+	"lox/bytecode"
+	"lox/compiler"
+	"lox/env"
+	"lox/interpreter"
+	"lox/loxerr"
+	"lox/token"
+)
+
+var _ interpreter.Runtime = (*VM)(nil)
+
+type VM struct {
+	*interpreter.Host
+
+	locals map[ast.Expr]int
+
+	stack        []any
+	frames       []frame
+	handlers     []handler
+	openUpvalues []*Upvalue // sorted by slot
+
+	limits interpreter.Limits
+	ctx    context.Context
+	steps  int
+
+	importer  interpreter.Importer
+	importing string // path of the import statement loading a module
+}
+
+// frame is an active call of a closure.
+type frame struct {
+	closure *Closure
+	ip      int
+	base    int    // stack index of slot 0
+	name    string // of the function in stack traces
+	main    bool   // runs the main script, which is not a call
+}
+
+// handler is an active try block.
+type handler struct {
+	frame int // index of the frame running the block
+	stack int // stack height when the block started
+	pc    int // of the code handling an error
+}
+
+// New returns a VM with a new Host.
+func New() *VM {
+	return &VM{
+		Host:   interpreter.NewHost(),
+		locals: make(map[ast.Expr]int),
+		limits: interpreter.Limits{MaxCallDepth: interpreter.DefaultMaxCallDepth},
+		ctx:    context.Background(),
+	}
+}
+
+func (vm *VM) Resolve(expr ast.Expr, depth int, slot int) {
+	vm.locals[expr] = depth
+}
+
+// SetLimits replaces the limits of later runs. MaxSteps counts
+// instructions rather than statements.
+func (vm *VM) SetLimits(limits interpreter.Limits) {
+	vm.limits = limits
+}
+
+// Start begins a run: it resets the step count, and makes the VM stop with
+// a runtime error once ctx is done. When ctx is done already, it returns
+// that error and the run must not go on.
+func (vm *VM) Start(ctx context.Context) error {
+	vm.ctx = ctx
+	vm.steps = 0
+	return interpreter.Interrupted(ctx)
+}
+
+// SetImporter makes import statements load modules with imp. Without an
+// importer, importing fails with a runtime error.
+func (vm *VM) SetImporter(imp interpreter.Importer) {
+	vm.importer = imp
+}
+
+// Interpret compiles and runs stmts, reporting the compile error or first
+// runtime error, if any.
+func (vm *VM) Interpret(stmts []ast.Stmt) (err error) {
+	fn, err := compiler.New(vm.locals).Compile(compiler.ScriptName, stmts)
+	if err != nil {
+		return err
+	}
+	return vm.Run(fn)
+}
+
+// Run runs a compiled script in the global environment of the main script,
+// reporting the first runtime error, if any.
+func (vm *VM) Run(fn *bytecode.Function) (err error) {
+	defer vm.recoverError(&err)
+
+	vm.runFunction(fn, vm.Main())
+	return nil
+}
+
+// Evaluate evaluates a single expression, such as the last line typed in a
+// REPL.
+func (vm *VM) Evaluate(expr ast.Expr) (val any, err error) {
+	fn, err := compiler.New(vm.locals).CompileExpression(compiler.ScriptName, expr)
+	if err != nil {
+		return nil, err
+	}
+
+	defer vm.recoverError(&err)
+	return vm.runFunction(fn, vm.Main()), nil
+}
+
+// Call calls callee from Go code, checking the number of arguments as a
+// call expression would.
+func (vm *VM) Call(callee interpreter.Callable, args []any) (val any, err error) {
+	defer vm.recoverError(&err)
+
+	if arity := callee.Arity(); arity != interpreter.Variadic && len(args) != arity {
+		return nil, &loxerr.RuntimeError{
+			Msg: fmt.Sprintf("Expected %d arguments but got %d.", arity, len(args)),
+		}
+	}
+	return callee.Call(vm, args), nil
+}
+
+// Callback calls callee on behalf of a built-in method, such as the
+// function passed to map.
+func (vm *VM) Callback(callee interpreter.Callable, args ...any) (any, error) {
+	if arity := callee.Arity(); arity != interpreter.Variadic && len(args) != arity {
+		return nil, fmt.Errorf("Expected %d arguments but got %d.", arity, len(args))
+	}
+	return callee.Call(vm, args), nil
+}
+
+// RunModule compiles and runs the resolved top-level statements of the
+// module name in a fresh global environment, enclosed by the built-ins.
+// Runtime errors in the module unwind to the import statement.
+func (vm *VM) RunModule(name string, stmts []ast.Stmt) (*interpreter.Module, error) {
+	// The module's top-level code shows up in stack traces as a call made
+	// by the import.
+	fn, err := compiler.New(vm.locals).Compile("<module "+vm.importing+">", stmts)
+	if err != nil {
+		return nil, err
+	}
+
+	globals := env.NewGlobal(vm.Builtins())
+	vm.runFunction(fn, globals)
+	return interpreter.NewModule(name, globals), nil
+}
+
+// runFunction runs the top-level function of a script or module with
+// globals as its global variables. Like in the tree-walker, it is not
+// checked against MaxCallDepth, and the main script, the one run first,
+// does not count toward it.
+func (vm *VM) runFunction(fn *bytecode.Function, globals *env.Env) any {
+	closure := &Closure{fn: fn, globals: globals}
+	vm.push(closure)
+	vm.frames = append(vm.frames, frame{
+		closure: closure,
+		base:    len(vm.stack) - 1,
+		name:    fn.Name,
+		main:    len(vm.frames) == 0,
+	})
+	return vm.run(len(vm.frames) - 1)
+}
+
+// callClosure calls c from Go code with slot0 as its slot 0, and runs it
+// to completion.
+func (vm *VM) callClosure(c *Closure, slot0 any, args []any, name string) any {
+	vm.push(slot0)
+	for _, arg := range args {
+		vm.push(arg)
+	}
+	vm.pushFrame(c, len(args), name)
+	return vm.run(len(vm.frames) - 1)
+}
+
+// recoverError stops a runtime error unwinding out of the VM and stores it
+// in err, along with the Lox stack trace.
+func (vm *VM) recoverError(err *error) {
+	if r := recover(); r != nil {
+		rtErr := vm.asRuntimeError(r)
+		// Closures made by the failed run outlive it, so their variables
+		// move off the stack before it is cleared.
+		vm.closeUpvalues(0)
+		vm.stack = vm.stack[:0]
+		vm.frames = vm.frames[:0]
+		vm.handlers = vm.handlers[:0]
+		*err = rtErr
+	}
+}
+
+// asRuntimeError gives a recovered runtime error the stack trace where it
+// was raised. Any other panic, such as a bad type assertion, is a bug in the
+// VM and keeps unwinding.
+func (vm *VM) asRuntimeError(r any) *loxerr.RuntimeError {
+	rtErr, ok := r.(*loxerr.RuntimeError)
+	if !ok {
+		panic(r)
+	}
+	if rtErr.Trace == nil {
+		rtErr.Trace = vm.stackTrace(rtErr.Position)
+	}
+	return rtErr
+}
+
+// stackTrace describes the active calls, innermost first. pos is where the
+// innermost frame was when the error was raised.
+func (vm *VM) stackTrace(pos token.Position) []loxerr.Frame {
+	trace := make([]loxerr.Frame, 0, len(vm.frames))
+	for n := len(vm.frames) - 1; n >= 0; n-- {
+		trace = append(trace, loxerr.Frame{Function: vm.frames[n].name, Position: pos})
+		if n > 0 {
+			caller := vm.frames[n-1]
+			pos = caller.closure.fn.Chunk.SpanAt(caller.ip - 1).Start
+		}
+	}
+	return trace
+}